@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package certsAdd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var AddCmd = &cobra.Command{
+	Use:   "add DOMAIN",
+	Short: "Serve your own certificate for a domain of your application",
+	Long: `This command uploads a certificate and its key to your VPS and switches the domain from Let's Encrypt to it.
+The certificate file can hold the full chain. Run it again with a renewed certificate to replace it.`,
+	Example: "  sidekick certs add example.com --cert ./example.com.pem --key ./example.com.key",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		host := args[0]
+		certPath, _ := cmd.Flags().GetString("cert")
+		keyPath, _ := cmd.Flags().GetString("key")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		certPEM, err := os.ReadFile(certPath)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatal(err)
+		}
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatal(err)
+		}
+		certificate, err := utils.ParseCertificate(certPEM, keyPEM, host)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatal(err)
+		}
+
+		domains := appConfig.AppDomains()
+		found := false
+		for i := range domains {
+			if domains[i].Host == host {
+				domains[i].CustomCert = true
+				found = true
+			}
+		}
+		if !found {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatalf("%s is not a domain of %s - add it first with sidekick domains add", host, appConfig.Name)
+		}
+		if appConfig.Wildcard && host == appConfig.PrimaryDomain() {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatalf("%s gets a wildcard certificate - set wildcard to false in sidekick.yml first", host)
+		}
+		appConfig.Domains = domains
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		historyEntry := utils.NewHistoryEntry("certs add")
+		historyEntry.Message = fmt.Sprintf("add certificate for %s", host)
+		spinner.New().
+			Title(fmt.Sprintf("Installing the certificate of %s...", host)).
+			Action(func() {
+				err = utils.WithLock(ctx, executor, utils.TraefikDir, "sidekick certs add", func() error {
+					if err := utils.InstallCertificate(ctx, executor, host, certPEM, keyPEM); err != nil {
+						return err
+					}
+					// servers set up before certs add need the file provider
+					return utils.RestartTraefik(ctx, executor, utils.LoadTraefikConfig())
+				})
+				if err != nil {
+					return
+				}
+				err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick certs add", func() error {
+					if err := utils.UpdateAppService(ctx, executor, appConfig); err != nil {
+						return err
+					}
+					ymlData, _ := yaml.Marshal(&appConfig)
+					return os.WriteFile("./sidekick.yml", ymlData, 0644)
+				})
+			}).
+			Run()
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Fatal(err)
+		}
+
+		render.GetLogger(log.Options{Prefix: "Certs"}).Infof("%s is now served with the certificate issued by %s", host, certificate.Issuer.CommonName)
+		render.GetLogger(log.Options{Prefix: "Certs"}).Infof("It expires on %s, in %d days", certificate.NotAfter.Format(time.DateOnly), int(time.Until(certificate.NotAfter).Hours()/24))
+	},
+}
+
+func init() {
+	AddCmd.Flags().String("cert", "", "PEM file with the certificate and its chain")
+	AddCmd.Flags().String("key", "", "PEM file with the private key of the certificate")
+	AddCmd.MarkFlagRequired("cert")
+	AddCmd.MarkFlagRequired("key")
+}
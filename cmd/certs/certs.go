@@ -0,0 +1,32 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package certs
+
+import (
+	certsAdd "github.com/mightymoud/sidekick/cmd/certs/add"
+	"github.com/spf13/cobra"
+)
+
+var CertsCmd = &cobra.Command{
+	Use:     "certs",
+	Aliases: []string{"cert"},
+	Short:   "Manage the TLS certificates of your application",
+	Long: `Traefik gets certificates from Let's Encrypt for your domains by default.
+These commands let you serve certificates issued by your own CA instead.`,
+}
+
+func init() {
+	CertsCmd.AddCommand(certsAdd.AddCmd)
+}
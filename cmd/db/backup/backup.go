@@ -0,0 +1,36 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbBackup
+
+import (
+	backupList "github.com/mightymoud/sidekick/cmd/db/backup/list"
+	backupNow "github.com/mightymoud/sidekick/cmd/db/backup/now"
+	backupSetup "github.com/mightymoud/sidekick/cmd/db/backup/setup"
+	"github.com/spf13/cobra"
+)
+
+var BackupCmd = &cobra.Command{
+	Use:     "backup",
+	Aliases: []string{"backups"},
+	Short:   "Back up the database of your application to S3 compatible storage",
+	Long: `These commands manage scheduled backups of the database of your application.
+Dumps are compressed and encrypted with age on your VPS before they are uploaded, so the bucket never sees your data.`,
+}
+
+func init() {
+	BackupCmd.AddCommand(backupSetup.SetupCmd)
+	BackupCmd.AddCommand(backupNow.NowCmd)
+	BackupCmd.AddCommand(backupList.ListCmd)
+}
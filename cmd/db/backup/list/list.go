@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package backupList
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the backups of your database",
+	Long:    `This command lists the backups of your database in the bucket, newest first. Restore one with sidekick db restore <id>.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal("Unable to login to your VPS")
+		}
+
+		var backups []utils.Backup
+		spinner.New().
+			Title("Listing your backups...").
+			Action(func() {
+				backups, err = utils.ListBackups(context.Background(), utils.NewSSHExecutor(sshClient), appConfig)
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal(err)
+		}
+		if len(backups) == 0 {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Infof("No backups of %s yet", appConfig.Name)
+			return
+		}
+
+		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render(fmt.Sprintf("Backups of %s:", appConfig.Name))
+		backupsTable := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				switch {
+				case row == 0:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("60")).Align(lipgloss.Center)
+				default:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
+				}
+			}).
+			Headers("ID", "Uploaded", "Size")
+		for _, backup := range backups {
+			backupsTable.Row(backup.ID, backup.Time, fmt.Sprintf("%.1f MB", float64(backup.Size)/1024/1024))
+		}
+		fmt.Println(header)
+		fmt.Println(backupsTable)
+	},
+}
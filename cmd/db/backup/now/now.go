@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package backupNow
+
+import (
+	"context"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var NowCmd = &cobra.Command{
+	Use:   "now",
+	Short: "Back up your database right away",
+	Long:  `This command runs the backup job of your application on your VPS now instead of waiting for its schedule.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		historyEntry := utils.NewHistoryEntry("db backup")
+		var id string
+		spinner.New().
+			Title("Backing up your database...").
+			Action(func() {
+				id, err = utils.BackupNow(ctx, executor, appConfig)
+			}).
+			Run()
+		historyEntry.Version = id
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Backup"}).Infof("Backed up %s as %s", appConfig.DatabaseConfig.Type, id)
+	},
+}
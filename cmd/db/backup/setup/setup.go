@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package backupSetup
+
+import (
+	"context"
+	"os"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var SetupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Schedule backups of your database to a bucket",
+	Long: `This command stores where backups go in sidekick.yml and the bucket credentials in the encrypted env file of your application.
+It then installs a cron job on your VPS that dumps, compresses, encrypts and uploads the database, keeping the latest backups only.
+Credentials default to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY from your environment.`,
+	Example: "  sidekick db backup setup --bucket backups --endpoint https://s3.eu-central-1.wasabisys.com --region eu-central-1",
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, _ := cmd.Flags().GetString("bucket")
+		bucketPath, _ := cmd.Flags().GetString("path")
+		region, _ := cmd.Flags().GetString("region")
+		endpoint, _ := cmd.Flags().GetString("endpoint")
+		schedule, _ := cmd.Flags().GetString("schedule")
+		retention, _ := cmd.Flags().GetInt("retention")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+		if appConfig.DatabaseConfig.Type == "" {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatalf("%s has no database - run sidekick db create first", appConfig.Name)
+		}
+
+		accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+		if accessKeyID == "" {
+			accessKeyID = render.GenerateTextQuestion("Please enter the access key id of your bucket", "", "")
+		}
+		secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if secretAccessKey == "" {
+			secretAccessKey = render.GenerateTextQuestion("Please enter the secret access key of your bucket", "", "")
+		}
+		if accessKeyID == "" || secretAccessKey == "" {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal("Bucket credentials are needed before you proceed")
+		}
+		render.RegisterSecret(secretAccessKey)
+
+		appConfig.DatabaseConfig.Backup = utils.SidekickAppDatabaseBackupConfig{
+			Target:       "s3",
+			BucketName:   bucket,
+			BucketPath:   bucketPath,
+			BucketRegion: region,
+			S3Endpoint:   endpoint,
+			Schedule:     schedule,
+			Retention:    retention,
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		spinner.New().
+			Title("Scheduling your backups...").
+			Action(func() {
+				err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick db backup setup", func() error {
+					env, err := utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+					if err != nil {
+						return err
+					}
+					env[utils.BackupCredentialKeys[0]] = accessKeyID
+					env[utils.BackupCredentialKeys[1]] = secretAccessKey
+					if err := utils.WriteRemoteEnv(ctx, executor, appConfig.Name, env); err != nil {
+						return err
+					}
+					appConfig.Env.Manage(utils.BackupCredentialKeys...)
+					if err := utils.InstallBackupJob(ctx, executor, appConfig); err != nil {
+						return err
+					}
+					ymlData, _ := yaml.Marshal(&appConfig)
+					return os.WriteFile("./sidekick.yml", ymlData, 0644)
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Backup"}).Fatal(err)
+		}
+
+		if schedule == "" {
+			schedule = utils.DefaultBackupSchedule
+		}
+		render.GetLogger(log.Options{Prefix: "Backup"}).Infof("Backups of %s run on %q to s3://%s - try one with sidekick db backup now", appConfig.DatabaseConfig.Type, schedule, bucket)
+	},
+}
+
+func init() {
+	SetupCmd.Flags().String("bucket", "", "Name of the bucket to upload backups to")
+	SetupCmd.Flags().String("path", "", "Folder in the bucket, backups go in <path>/<app>")
+	SetupCmd.Flags().String("region", "us-east-1", "Region of the bucket")
+	SetupCmd.Flags().String("endpoint", "", "Endpoint of S3 compatible storage, like http://minio:9000")
+	SetupCmd.Flags().String("schedule", utils.DefaultBackupSchedule, "When to back up, as a cron expression")
+	SetupCmd.Flags().Int("retention", utils.DefaultBackupRetention, "Number of backups to keep")
+	SetupCmd.MarkFlagRequired("bucket")
+}
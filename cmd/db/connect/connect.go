@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbConnect
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ConnectCmd = &cobra.Command{
+	Use:   "connect",
+	Short: "Connect to the database of your application",
+	Long: `This command forwards a local port over SSH to the database of your application and opens psql, mysql or redis-cli with the credentials from your encrypted env file.
+Use --url to only print a connection URL for GUI tools; the tunnel stays open until you press Ctrl+C.`,
+	Example: "  sidekick db connect\n  sidekick db connect --url --port 15432",
+	Run: func(cmd *cobra.Command, args []string) {
+		urlOnly, _ := cmd.Flags().GetBool("url")
+		port, _ := cmd.Flags().GetInt("port")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+		engine, ok := utils.DatabaseEngines[appConfig.DatabaseConfig.Type]
+		if !ok {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatalf("%s has no database - run sidekick db create first", appConfig.Name)
+		}
+		if !urlOnly {
+			if _, err := exec.LookPath(engine.Client); err != nil {
+				render.GetLogger(log.Options{Prefix: "Database"}).Fatalf("%s not found on your machine - install it or use --url", engine.Client)
+			}
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal("Unable to login to your VPS")
+		}
+		defer sshClient.Close()
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		if err := utils.EnsureAgeKey(ctx, executor); err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+		env, err := utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+		for _, value := range env {
+			render.RegisterSecret(value)
+		}
+		remoteAddr, err := utils.DatabaseAddress(ctx, executor, appConfig)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+
+		listener, err := utils.ForwardPort(sshClient, fmt.Sprintf("127.0.0.1:%d", port), remoteAddr)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+		defer listener.Close()
+		connection, err := utils.DatabaseConnectionAt(appConfig, env, listener.Addr().String())
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		if urlOnly {
+			render.GetLogger(log.Options{Prefix: "Database"}).Infof("Forwarding %s to the %s database of %s - press Ctrl+C to stop", listener.Addr(), appConfig.DatabaseConfig.Type, appConfig.Name)
+			fmt.Println(connection.URL)
+			<-interrupt
+			return
+		}
+
+		// the client handles Ctrl+C itself, so we only keep it from killing the tunnel
+		client := exec.Command(engine.Client, connection.Args...)
+		client.Env = append(os.Environ(), connection.Env...)
+		client.Stdin = os.Stdin
+		client.Stdout = os.Stdout
+		client.Stderr = os.Stderr
+		if err := client.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+			}
+		}
+	},
+}
+
+func init() {
+	ConnectCmd.Flags().Bool("url", false, "Print a connection URL and keep the tunnel open instead of opening a client")
+	ConnectCmd.Flags().IntP("port", "p", 0, "Local port to forward, a free one by default")
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbCreate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var CreateCmd = &cobra.Command{
+	Use:   "create postgres|mysql|redis",
+	Short: "Add a database to your application",
+	Long: `This command adds a database service to the compose file of your application with a named volume for its data.
+It generates credentials into the encrypted env file of your application - DATABASE_URL, or REDIS_URL for redis - and makes your application wait for the database to be healthy.
+Use --restart so your running application gets the new values right away.`,
+	Example:   "  sidekick db create postgres --restart",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: utils.DatabaseTypes(),
+	Run: func(cmd *cobra.Command, args []string) {
+		dbType := args[0]
+		restart, _ := cmd.Flags().GetBool("restart")
+		dbName, _ := cmd.Flags().GetString("name")
+		image, _ := cmd.Flags().GetString("image")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+		if appConfig.DatabaseConfig.Type != "" {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatalf("%s already has a %s database", appConfig.Name, appConfig.DatabaseConfig.Type)
+		}
+		if dbName == "" {
+			dbName = utils.DefaultDatabaseName(appConfig.Name)
+		}
+		appConfig.DatabaseConfig.Type = dbType
+		appConfig.DatabaseConfig.Image = image
+		appConfig.DatabaseConfig.DbName = dbName
+
+		credentials, err := utils.DatabaseCredentials(appConfig)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+		for _, value := range credentials {
+			render.RegisterSecret(value)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		historyEntry := utils.NewHistoryEntry("db create")
+		err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick db create", func() error {
+			env, err := utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+			if err != nil {
+				return err
+			}
+			for key, value := range credentials {
+				if _, ok := env[key]; ok {
+					return fmt.Errorf("%s is already set on your VPS - unset it first with sidekick env unset %s", key, key)
+				}
+				env[key] = value
+			}
+			if err := utils.WriteRemoteEnv(ctx, executor, appConfig.Name, env); err != nil {
+				return err
+			}
+			historyEntry.EnvHash = utils.EnvHash(env)
+
+			// the credentials only live on the server, like keys set with sidekick env set
+			appConfig.Env.Manage(utils.EnvKeys(credentials)...)
+			if err := utils.WriteAppCompose(ctx, executor, appConfig, utils.EnvKeys(env)); err != nil {
+				return err
+			}
+			ymlData, _ := yaml.Marshal(&appConfig)
+			if err := os.WriteFile("./sidekick.yml", ymlData, 0644); err != nil {
+				return err
+			}
+
+			var startErr error
+			spinner.New().
+				Title(fmt.Sprintf("Starting %s...", dbType)).
+				Action(func() {
+					if startErr = utils.EnsureAgeKey(ctx, executor); startErr != nil {
+						return
+					}
+					startCmd := fmt.Sprintf("docker compose -p sidekick up -d --wait %s", utils.DatabaseServiceName(appConfig.Name))
+					if _, startErr = executor.Run(ctx, fmt.Sprintf("cd %s && %s", appConfig.Name, utils.SopsExecEnv("encrypted.env", startCmd))); startErr != nil {
+						return
+					}
+					if restart {
+						_, startErr = utils.RestartWithEnv(ctx, executor, appConfig)
+					}
+				}).
+				Run()
+			return startErr
+		})
+		historyEntry.Message = fmt.Sprintf("created %s database %s", dbType, dbName)
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Database"}).Fatal(err)
+		}
+
+		keys := utils.EnvKeys(credentials)
+		render.GetLogger(log.Options{Prefix: "Database"}).Infof("%s is running as %s, your app can reach it with %s", dbType, utils.DatabaseServiceName(appConfig.Name), strings.Join(keys, ", "))
+		if !restart {
+			render.GetLogger(log.Options{Prefix: "Database"}).Info("Your app gets the new values on your next deploy")
+		}
+	},
+}
+
+func init() {
+	CreateCmd.Flags().Bool("restart", false, "Replace the running container of your app so it gets the credentials right away")
+	CreateCmd.Flags().String("name", "", "Name of the database and its user, defaults to the app name")
+	CreateCmd.Flags().String("image", "", "Docker image of the database, defaults to a recent official one")
+}
@@ -0,0 +1,37 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package db
+
+import (
+	dbBackup "github.com/mightymoud/sidekick/cmd/db/backup"
+	dbConnect "github.com/mightymoud/sidekick/cmd/db/connect"
+	dbCreate "github.com/mightymoud/sidekick/cmd/db/create"
+	dbRestore "github.com/mightymoud/sidekick/cmd/db/restore"
+	"github.com/spf13/cobra"
+)
+
+var DbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Run a database next to your application on your VPS",
+	Long: `These commands manage a database that runs as a container next to your application.
+Its data lives in a docker volume and its credentials in the encrypted env file of your application.`,
+}
+
+func init() {
+	DbCmd.AddCommand(dbCreate.CreateCmd)
+	DbCmd.AddCommand(dbBackup.BackupCmd)
+	DbCmd.AddCommand(dbConnect.ConnectCmd)
+	DbCmd.AddCommand(dbRestore.RestoreCmd)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dbRestore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var RestoreCmd = &cobra.Command{
+	Use:     "restore ID",
+	Short:   "Restore your database from a backup",
+	Long:    `This command downloads a backup from the bucket, decrypts it on your VPS and loads it into your database, replacing its data.`,
+	Example: "  sidekick db restore 20261018T030000Z",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+		skipPromptsFlag, _ := cmd.Flags().GetBool("yes")
+		if !utils.ValidBackupID(id) {
+			render.GetLogger(log.Options{Prefix: "Restore"}).Fatalf("%s is not a backup id - Run sidekick db backup list to see them", id)
+		}
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		if !skipPromptsFlag {
+			confirm := render.GenerateTextQuestion(fmt.Sprintf("This replaces the data of your %s database with backup %s. Continue? (y/n)", appConfig.DatabaseConfig.Type, id), "n", "")
+			if strings.ToLower(confirm) != "y" {
+				os.Exit(0)
+			}
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Restore"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		historyEntry := utils.NewHistoryEntry("db restore")
+		historyEntry.Version = id
+		spinner.New().
+			Title(fmt.Sprintf("Restoring backup %s...", id)).
+			Action(func() {
+				err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick db restore", func() error {
+					return utils.RestoreBackup(ctx, executor, appConfig, id)
+				})
+			}).
+			Run()
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Restore"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Restore"}).Infof("Restored %s from backup %s", appConfig.DatabaseConfig.Type, id)
+	},
+}
+
+func init() {
+	RestoreCmd.Flags().BoolP("yes", "y", false, "Skip the confirmation prompt")
+}
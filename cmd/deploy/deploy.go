@@ -15,7 +15,8 @@ limitations under the License.
 package deploy
 
 import (
-	"crypto/md5"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -23,7 +24,6 @@ import (
 	"strings"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
 	teaLog "github.com/charmbracelet/log"
 	"github.com/mightymoud/sidekick/render"
 	"github.com/mightymoud/sidekick/utils"
@@ -62,129 +62,136 @@ func stage1Login() (*ssh.Client, error) {
 	return sshClient, err
 }
 
-func stage2EnvFile(appConfig utils.SidekickAppConfig, p *tea.Program) (bool, string, error) {
-	defer os.Remove("encrypted.env")
-	envFileChanged := false
-	currentEnvFileHash := ""
-	if appConfig.Env.File != "" {
-		envFileContent, envFileErr := os.ReadFile(fmt.Sprintf("./%s", appConfig.Env.File))
-		if envFileErr != nil {
-			return false, "", fmt.Errorf("failed to read environment file: %w", envFileErr)
-		}
-		currentEnvFileHash = fmt.Sprintf("%x", md5.Sum(envFileContent))
-		envFileChanged = appConfig.Env.Hash != currentEnvFileHash
-		if envFileChanged {
-			// encrypt new env file
-			envCmd := exec.Command("sh", "-s", "-", viper.GetString("publicKey"), fmt.Sprintf("./%s", appConfig.Env.File))
-			envCmd.Stdin = strings.NewReader(utils.EnvEncryptionScript)
-			envCmdErrPipe, _ := envCmd.StderrPipe()
-			go render.SendLogsToTUI(envCmdErrPipe, p)
-			if envCmdErr := envCmd.Run(); envCmdErr != nil {
-				return false, "", fmt.Errorf("failed to encrypt environment file: %w", envCmdErr)
-			}
-			encryptSyncCmd := exec.Command("rsync", "-v", "encrypted.env", fmt.Sprintf("%s@%s:%s", "sidekick", viper.Get("serverAddress").(string), fmt.Sprintf("./%s", appConfig.Name)))
-			encryptSyncCmdErrPipe, _ := encryptSyncCmd.StderrPipe()
-			go render.SendLogsToTUI(encryptSyncCmdErrPipe, p)
-			if encryptSyncCmdErr := encryptSyncCmd.Run(); encryptSyncCmdErr != nil {
-				return false, "", fmt.Errorf("failed to sync encrypted environment file to server: %w", encryptSyncCmdErr)
-			}
-		}
+// stage2EnvFile compares the env the app should run with, its env files plus
+// the keys managed on the server, to the one on the server and reports
+// whether a key was added, removed or changed. The server copy is only
+// re-encrypted in stage6Deploy so a failed deploy keeps the old env
+func stage2EnvFile(ctx context.Context, executor utils.Executor, appConfig utils.SidekickAppConfig, p render.Output) (map[string]string, bool, error) {
+	if !appConfig.Env.HasEnv() {
+		return map[string]string{}, false, nil
+	}
+	serverEnv, err := utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+	if err != nil {
+		return nil, false, err
 	}
-	return envFileChanged, currentEnvFileHash, nil
+
+	// keys set with sidekick env set keep their server values
+	env, err := utils.AppEnv(appConfig.Env, serverEnv)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changes := utils.DiffEnv(serverEnv, env)
+	if changes.Empty() {
+		p.Send(render.LogMsg{LogLine: "No env changes\n"})
+		return env, false, nil
+	}
+	p.Send(render.LogMsg{LogLine: "Env changes:\n" + strings.Join(changes.Lines(), "\n") + "\n"})
+	return env, true, nil
 }
 
-func stage3BuildDockerImage(appConfig utils.SidekickAppConfig, p *tea.Program) error {
+func stage3BuildDockerImage(ctx context.Context, executor utils.Executor, appConfig utils.SidekickAppConfig, p render.Output) error {
 	cwd, _ := os.Getwd()
 	dockerPlatformId := viper.GetString("platformID")
-	dockerBuildCmd := exec.Command("docker", "build", "--tag", appConfig.Name, "--progress=plain", fmt.Sprintf("--platform=%s", dockerPlatformId), cwd)
-	dockerBuildCmdErrPipe, _ := dockerBuildCmd.StderrPipe()
-	go render.SendLogsToTUI(dockerBuildCmdErrPipe, p)
-
-	if dockerBuildErr := dockerBuildCmd.Run(); dockerBuildErr != nil {
+	dockerBuildArgs := []string{"build", "--tag", appConfig.Name, "--progress=plain", fmt.Sprintf("--platform=%s", dockerPlatformId), cwd}
+	dockerBuildErr := executor.Local("docker "+strings.Join(dockerBuildArgs, " "), func() error {
+		dockerBuildCmd := exec.CommandContext(ctx, "docker", dockerBuildArgs...)
+		dockerBuildCmdErrPipe, _ := dockerBuildCmd.StderrPipe()
+		go render.SendLogsToTUI(dockerBuildCmdErrPipe, p)
+		return dockerBuildCmd.Run()
+	})
+	if dockerBuildErr != nil {
 		return fmt.Errorf("failed to build Docker image: %w", dockerBuildErr)
 	}
 	return nil
 }
 
-func stage4SaveDockerImage(appConfig utils.SidekickAppConfig, p *tea.Program) error {
+func stage4SaveDockerImage(ctx context.Context, executor utils.Executor, appConfig utils.SidekickAppConfig, p render.Output) error {
 	imgFileName := fmt.Sprintf("%s-latest.tar", appConfig.Name)
-	imgSaveCmd := exec.Command("docker", "save", "-o", imgFileName, appConfig.Name)
-	imgSaveCmdErrPipe, _ := imgSaveCmd.StderrPipe()
-	go render.SendLogsToTUI(imgSaveCmdErrPipe, p)
-
-	if imgSaveCmdErr := imgSaveCmd.Run(); imgSaveCmdErr != nil {
+	imgSaveCmdErr := executor.Local(fmt.Sprintf("docker save -o %s %s", imgFileName, appConfig.Name), func() error {
+		imgSaveCmd := exec.CommandContext(ctx, "docker", "save", "-o", imgFileName, appConfig.Name)
+		imgSaveCmdErrPipe, _ := imgSaveCmd.StderrPipe()
+		go render.SendLogsToTUI(imgSaveCmdErrPipe, p)
+		return imgSaveCmd.Run()
+	})
+	if imgSaveCmdErr != nil {
 		return fmt.Errorf("failed to save Docker image: %w", imgSaveCmdErr)
 	}
 	return nil
 }
 
-func stage5MoveDockerImage(appConfig utils.SidekickAppConfig, p *tea.Program) error {
+func stage5MoveDockerImage(ctx context.Context, executor utils.Executor, appConfig utils.SidekickAppConfig, p render.Output) error {
 	imgFileName := fmt.Sprintf("%s-latest.tar", appConfig.Name)
-	remoteDist := fmt.Sprintf("%s@%s:./%s", "sidekick", viper.GetString("serverAddress"), appConfig.Name)
-	imgMoveCmd := exec.Command("scp", "-C", imgFileName, remoteDist)
-	imgMoveCmdErrorPipe, _ := imgMoveCmd.StderrPipe()
-	go render.SendLogsToTUI(imgMoveCmdErrorPipe, p)
-
-	if imgMovCmdErr := imgMoveCmd.Run(); imgMovCmdErr != nil {
+	if imgMovCmdErr := executor.Upload(ctx, imgFileName, fmt.Sprintf("./%s", appConfig.Name), p); imgMovCmdErr != nil {
 		return fmt.Errorf("failed to move Docker image to server: %w", imgMovCmdErr)
 	}
 	os.Remove(imgFileName)
 	return nil
 }
 
-func stage6Deploy(sshClient *ssh.Client, appConfig utils.SidekickAppConfig, envFileChanged bool, currentEnvFileHash string, p *tea.Program) error {
-	dockerLoadOutChan, _, sessionErr := utils.RunCommand(sshClient, fmt.Sprintf("cd %s && docker load -i %s-latest.tar", appConfig.Name, appConfig.Name))
+func stage6Deploy(ctx context.Context, executor utils.Executor, appConfig utils.SidekickAppConfig, env map[string]string, envChanged bool, p render.Output) error {
+	// regenerate the compose file so keys added to the env files reach the container
+	if err := utils.EnsureTraefikEntryPoints(ctx, executor, appConfig); err != nil {
+		return err
+	}
+	if err := utils.WriteAppCompose(ctx, executor, appConfig, utils.EnvKeys(env)); err != nil {
+		return err
+	}
+
+	dockerLoadOut, sessionErr := executor.Run(ctx, fmt.Sprintf("cd %s && docker load -i %s-latest.tar", appConfig.Name, appConfig.Name))
 	if sessionErr != nil {
 		return fmt.Errorf("failed to load docker image on server: %w", sessionErr)
 	}
-
-	go func() {
-		p.Send(render.LogMsg{LogLine: <-dockerLoadOutChan + "\n"})
-		time.Sleep(time.Millisecond * 100)
-	}()
+	p.Send(render.LogMsg{LogLine: dockerLoadOut})
 
 	replacer := strings.NewReplacer(
 		"$service_name", appConfig.Name,
 		"$app_port", fmt.Sprint(appConfig.Port),
-		"$age_secret_key", viper.GetString("secretKey"),
 	)
 
-	if appConfig.Env.File != "" {
+	if appConfig.Env.HasEnv() {
+		if err := utils.EnsureAgeKey(ctx, executor); err != nil {
+			return err
+		}
+		if envChanged {
+			if encryptSyncErr := utils.WriteRemoteEnv(ctx, executor, appConfig.Name, env); encryptSyncErr != nil {
+				return fmt.Errorf("failed to sync encrypted environment file to server: %w", encryptSyncErr)
+			}
+		}
 		deployScript := replacer.Replace(utils.DeployAppWithEnvScript)
-		_, runVersionOutChan, sessionErr := utils.RunCommand(sshClient, deployScript)
+		runVersionOut, sessionErr := executor.Run(ctx, deployScript)
 		if sessionErr != nil {
 			return fmt.Errorf("failed to deploy application with environment file: %w", sessionErr)
 		}
-		go func() {
-			p.Send(render.LogMsg{LogLine: <-runVersionOutChan + "\n"})
-			time.Sleep(time.Millisecond * 100)
-		}()
+		p.Send(render.LogMsg{LogLine: runVersionOut})
 	} else {
 		deployScript := replacer.Replace(utils.DeployApp)
-		utils.RunCommandWithTUIHook(sshClient, deployScript, p)
+		if err := executor.Stream(ctx, deployScript, p); err != nil {
+			return fmt.Errorf("failed to deploy application: %w", err)
+		}
 		time.Sleep(time.Second * 2)
 	}
 
-	cleanOutChan, _, sessionErr := utils.RunCommand(sshClient, fmt.Sprintf("cd %s && rm %s", appConfig.Name, fmt.Sprintf("%s-latest.tar", appConfig.Name)))
+	cleanOut, sessionErr := executor.Run(ctx, fmt.Sprintf("cd %s && rm %s", appConfig.Name, fmt.Sprintf("%s-latest.tar", appConfig.Name)))
 	if sessionErr != nil {
 		return fmt.Errorf("failed to clean up image file on server: %w", sessionErr)
 	}
-	go func() {
-		p.Send(render.LogMsg{LogLine: <-cleanOutChan + "\n"})
-		time.Sleep(time.Millisecond * 100)
-	}()
+	p.Send(render.LogMsg{LogLine: cleanOut})
 
-	latestVersion := strings.Split(appConfig.Version, "")[1]
-	latestVersionInt, _ := strconv.ParseInt(latestVersion, 0, 64)
-	appConfig.Version = fmt.Sprintf("V%d", latestVersionInt+1)
-	// env file changed ? -> update hash
-	if envFileChanged {
-		appConfig.Env.Hash = currentEnvFileHash
+	appConfig.Version = nextVersion(appConfig.Version)
+	if appConfig.Env.HasEnv() {
+		appConfig.Env.Hash = utils.EnvHash(env)
 	}
-	ymlData, _ := yaml.Marshal(&appConfig)
-	os.WriteFile("./sidekick.yml", ymlData, 0644)
+	return executor.Local(fmt.Sprintf("update sidekick.yml to version %s", appConfig.Version), func() error {
+		ymlData, _ := yaml.Marshal(&appConfig)
+		return os.WriteFile("./sidekick.yml", ymlData, 0644)
+	})
+}
 
-	return nil
+func nextVersion(version string) string {
+	latestVersion := strings.TrimPrefix(version, "V")
+	latestVersionInt, _ := strconv.ParseInt(latestVersion, 0, 64)
+	return fmt.Sprintf("V%d", latestVersionInt+1)
 }
 
 var DeployCmd = &cobra.Command{
@@ -197,70 +204,100 @@ It assumes that your VPS is already configured and that your application is read
 
 		appConfig := prelude()
 
+		var plan *utils.Plan
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			plan = utils.NewPlan("sidekick deploy")
+		}
+
+		ctx, cancel := utils.CancelContext()
+		defer cancel()
+
 		cmdStages := []render.Stage{
 			render.MakeStage("Validating connection with VPS", "VPS is reachable", false),
-			render.MakeStage("Updating secrets if needed", "Env file check complete", false),
+			render.MakeStage("Updating secrets if needed", "Env file check complete", true),
 			render.MakeStage("Building latest docker image of your app", "Latest docker image built", true),
 			render.MakeStage("Saving docker image locally", "Image saved successfully", false),
 			render.MakeStage("Moving image to your server", "Image moved and loaded successfully", false),
 			render.MakeStage("Deploying a new version of your application", "Deployed new version successfully", true),
 		}
-		p := tea.NewProgram(render.TuiModel{
+		p := render.NewOutput(render.TuiModel{
 			Stages:      cmdStages,
 			BannerMsg:   "Deploying a new env of your app 😎",
 			ActiveIndex: 0,
 			Quitting:    false,
 			AllDone:     false,
+			Cancel:      cancel,
 		})
 
 		go func() {
+			orchestrator := utils.NewOrchestrator(ctx, p)
+
 			sshClient, err := stage1Login()
-			if err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: "Failed to connect to VPS: " + err.Error()})
+			if orchestrator.Failed(err, "Failed to connect to VPS") {
 				return
 			}
+			executor := utils.NewExecutor(sshClient, plan)
+			if err := orchestrator.Lock(executor, appConfig.Name, "sidekick deploy"); orchestrator.Failed(err, "") {
+				return
+			}
+			historyEntry := utils.NewHistoryEntry("deploy")
+			historyEntry.Version = nextVersion(appConfig.Version)
+			historyEntry.Image = appConfig.Name
+			historyEntry.EnvHash = appConfig.Env.Hash
+			orchestrator.Record(executor, appConfig.Name, historyEntry)
 			p.Send(render.NextStageMsg{})
 
-			envFileChanged, currentEnvFileHash, err := stage2EnvFile(appConfig, p)
-			if err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: err.Error()})
+			imgFileName := fmt.Sprintf("%s-latest.tar", appConfig.Name)
+			orchestrator.OnCancel(func(ctx context.Context) string {
+				os.Remove(imgFileName)
+				return utils.RollbackService(ctx, executor, appConfig.Name, appConfig.Name, 1, fmt.Sprintf("rm -f %s", imgFileName))
+			})
+
+			env, envChanged, err := stage2EnvFile(ctx, executor, appConfig, p)
+			if orchestrator.Failed(err, "") {
 				return
 			}
+			if appConfig.Env.HasEnv() {
+				historyEntry.EnvHash = utils.EnvHash(env)
+			}
 			p.Send(render.NextStageMsg{})
 
-			if err := stage3BuildDockerImage(appConfig, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: err.Error()})
+			if err := stage3BuildDockerImage(ctx, executor, appConfig, p); orchestrator.Failed(err, "") {
 				return
 			}
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage4SaveDockerImage(appConfig, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: err.Error()})
+			if err := stage4SaveDockerImage(ctx, executor, appConfig, p); orchestrator.Failed(err, "") {
 				return
 			}
 			time.Sleep(time.Millisecond * 200)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage5MoveDockerImage(appConfig, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: err.Error()})
+			if err := stage5MoveDockerImage(ctx, executor, appConfig, p); orchestrator.Failed(err, "") {
 				return
 			}
 			time.Sleep(time.Millisecond * 200)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage6Deploy(sshClient, appConfig, envFileChanged, currentEnvFileHash, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: err.Error()})
+			if err := stage6Deploy(ctx, executor, appConfig, env, envChanged, p); orchestrator.Failed(err, "") {
 				return
 			}
 
 			time.Sleep(time.Millisecond * 500)
-			p.Send(render.AllDoneMsg{Message: "🚀 Deployed successfully in " + time.Since(start).Round(time.Second).String() + ".\n" + "😎 View your app at https://" + appConfig.Url})
+			orchestrator.Done(plan.DoneMessage("🚀 Deployed successfully in " + time.Since(start).Round(time.Second).String() + ".\n" + "😎 View your app at https://" + appConfig.Url))
 		}()
 
-		if _, err := p.Run(); err != nil {
-			fmt.Println("Error running program:", err)
+		if err := p.Run(); err != nil {
+			if !errors.Is(err, render.ErrStageFailed) {
+				fmt.Println("Error running program:", err)
+			}
 			os.Exit(1)
 		}
+		plan.Print(os.Stdout)
 	},
 }
+
+func init() {
+	DeployCmd.Flags().Bool("dry-run", false, "Show what deploy would change on your VPS without changing anything")
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package domainsAdd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var AddCmd = &cobra.Command{
+	Use:   "add DOMAIN",
+	Short: "Serve your application on another domain",
+	Long: `This command adds a domain to your application and updates the running service, without building a new image.
+Use --redirect to send visitors of the domain to the primary one, like www.example.com to example.com.`,
+	Example: "  sidekick domains add example.com --primary\n  sidekick domains add www.example.com --redirect\n  sidekick domains add api.example.com --path /v1",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		primary, _ := cmd.Flags().GetBool("primary")
+		redirect, _ := cmd.Flags().GetBool("redirect")
+		pathPrefix, _ := cmd.Flags().GetString("path")
+		skipDNSCheck, _ := cmd.Flags().GetBool("skip-dns-check")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		domain := utils.SidekickAppDomainConfig{Host: args[0], Primary: primary, Redirect: redirect, PathPrefix: pathPrefix}
+		if err := utils.ValidateDomain(domain); err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal(err)
+		}
+		domains := appConfig.AppDomains()
+		for _, existing := range domains {
+			if existing.Host == domain.Host && existing.PathPrefix == domain.PathPrefix {
+				render.GetLogger(log.Options{Prefix: "Domains"}).Fatalf("%s is already a domain of %s", domain.Host, appConfig.Name)
+			}
+		}
+		if primary {
+			for i := range domains {
+				domains[i].Primary = false
+			}
+			appConfig.Url = domain.Host
+		}
+		appConfig.Domains = append(domains, domain)
+		if err := utils.ValidateDomains(appConfig.Domains); err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal(err)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		if !skipDNSCheck {
+			serverIPs := utils.ServerIPs(ctx, executor, viper.GetString("serverAddress"))
+			if err := utils.PreflightDNS(ctx, []string{domain.Host}, serverIPs); err != nil {
+				render.GetLogger(log.Options{Prefix: "DNS"}).Fatal(err)
+			}
+		}
+
+		historyEntry := utils.NewHistoryEntry("domains add")
+		historyEntry.Message = fmt.Sprintf("add %s", domain.Host)
+		spinner.New().
+			Title(fmt.Sprintf("Adding %s to your application...", domain.Host)).
+			Action(func() {
+				err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick domains add", func() error {
+					if err := utils.UpdateAppService(ctx, executor, appConfig); err != nil {
+						return err
+					}
+					ymlData, _ := yaml.Marshal(&appConfig)
+					return os.WriteFile("./sidekick.yml", ymlData, 0644)
+				})
+			}).
+			Run()
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal(err)
+		}
+
+		spinner.New().
+			Title(fmt.Sprintf("Waiting for a certificate for %s...", domain.Host)).
+			Action(func() {
+				err = utils.VerifyCertificate(ctx, executor, domain.Host)
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Certificate"}).Warn(err)
+		}
+		if redirect {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Infof("https://%s now redirects to https://%s", domain.Host, appConfig.PrimaryDomain())
+			return
+		}
+		render.GetLogger(log.Options{Prefix: "Domains"}).Infof("%s is now served on https://%s%s", appConfig.Name, domain.Host, domain.PathPrefix)
+	},
+}
+
+func init() {
+	AddCmd.Flags().Bool("primary", false, "Make this the main domain of your application")
+	AddCmd.Flags().Bool("redirect", false, "Redirect visitors of this domain to the primary one")
+	AddCmd.Flags().String("path", "", "Only route requests under this path prefix")
+	AddCmd.Flags().Bool("skip-dns-check", false, "Add the domain even if it doesn't point to your VPS yet")
+	AddCmd.MarkFlagsMutuallyExclusive("primary", "redirect")
+}
@@ -0,0 +1,36 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package domains
+
+import (
+	domainsAdd "github.com/mightymoud/sidekick/cmd/domains/add"
+	domainsList "github.com/mightymoud/sidekick/cmd/domains/list"
+	domainsRemove "github.com/mightymoud/sidekick/cmd/domains/remove"
+	"github.com/spf13/cobra"
+)
+
+var DomainsCmd = &cobra.Command{
+	Use:     "domains",
+	Aliases: []string{"domain"},
+	Short:   "Manage the domains your application is served on",
+	Long: `These commands edit the domains of your application in sidekick.yml and update the Traefik routers of the running service.
+Point the domains at your VPS first so Traefik can get certificates for them.`,
+}
+
+func init() {
+	DomainsCmd.AddCommand(domainsAdd.AddCmd)
+	DomainsCmd.AddCommand(domainsRemove.RemoveCmd)
+	DomainsCmd.AddCommand(domainsList.ListCmd)
+}
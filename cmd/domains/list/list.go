@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package domainsList
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+)
+
+var ListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the domains of your application",
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render(fmt.Sprintf("Domains of %s:", appConfig.Name))
+		domainsTable := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				switch {
+				case row == 0:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("60")).Align(lipgloss.Center)
+				default:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
+				}
+			}).
+			Headers("Domain", "Path", "Serves")
+		primary := appConfig.PrimaryDomain()
+		for _, domain := range appConfig.AppDomains() {
+			serves := "app"
+			if domain.Host == primary {
+				serves = "app (primary)"
+			} else if domain.Redirect {
+				serves = "redirect to " + primary
+			}
+			path := domain.PathPrefix
+			if path == "" {
+				path = "/"
+			}
+			domainsTable.Row(domain.Host, path, serves)
+		}
+		fmt.Println(header)
+		fmt.Println(domainsTable)
+	},
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package domainsRemove
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var RemoveCmd = &cobra.Command{
+	Use:     "remove DOMAIN",
+	Aliases: []string{"rm"},
+	Short:   "Stop serving your application on a domain",
+	Long:    `This command removes a domain from your application and updates the running service. The primary domain can't be removed - make another one primary first.`,
+	Example: "  sidekick domains remove www.example.com",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		host := args[0]
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+		if host == appConfig.PrimaryDomain() {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatalf("%s is the primary domain of %s - add another one with --primary first", host, appConfig.Name)
+		}
+
+		domains := []utils.SidekickAppDomainConfig{}
+		for _, domain := range appConfig.AppDomains() {
+			if domain.Host != host {
+				domains = append(domains, domain)
+			}
+		}
+		if len(domains) == len(appConfig.AppDomains()) {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatalf("%s is not a domain of %s", host, appConfig.Name)
+		}
+		if err := utils.ValidateDomains(domains); err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal(err)
+		}
+		appConfig.Domains = domains
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		historyEntry := utils.NewHistoryEntry("domains remove")
+		historyEntry.Message = fmt.Sprintf("remove %s", host)
+		spinner.New().
+			Title(fmt.Sprintf("Removing %s from your application...", host)).
+			Action(func() {
+				err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick domains remove", func() error {
+					if err := utils.UpdateAppService(ctx, executor, appConfig); err != nil {
+						return err
+					}
+					ymlData, _ := yaml.Marshal(&appConfig)
+					return os.WriteFile("./sidekick.yml", ymlData, 0644)
+				})
+			}).
+			Run()
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Domains"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Domains"}).Infof("Removed %s", host)
+	},
+}
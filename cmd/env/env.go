@@ -0,0 +1,37 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package env
+
+import (
+	envList "github.com/mightymoud/sidekick/cmd/env/list"
+	envPull "github.com/mightymoud/sidekick/cmd/env/pull"
+	envSet "github.com/mightymoud/sidekick/cmd/env/set"
+	envUnset "github.com/mightymoud/sidekick/cmd/env/unset"
+	"github.com/spf13/cobra"
+)
+
+var EnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage the secrets of your application on your VPS",
+	Long: `These commands edit the sops encrypted env file of your application directly on your VPS.
+Values are only ever decrypted in memory on your machine.`,
+}
+
+func init() {
+	EnvCmd.AddCommand(envSet.SetCmd)
+	EnvCmd.AddCommand(envUnset.UnsetCmd)
+	EnvCmd.AddCommand(envList.ListCmd)
+	EnvCmd.AddCommand(envPull.PullCmd)
+}
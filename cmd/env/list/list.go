@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package envList
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/joho/godotenv"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the secrets of your application on your VPS",
+	Long:    `This command lists the keys in the encrypted env file of your application on your VPS. Values are hidden unless you pass --values.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		showValues, _ := cmd.Flags().GetBool("values")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal("Unable to login to your VPS")
+		}
+
+		env, err := utils.ReadRemoteEnv(context.Background(), utils.NewSSHExecutor(sshClient), appConfig.Name)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal(err)
+		}
+		if len(env) == 0 && len(appConfig.Env.Vars) == 0 {
+			render.GetLogger(log.Options{Prefix: "Env"}).Infof("No env set for %s", appConfig.Name)
+			return
+		}
+
+		headers := []string{"Key", "Source"}
+		if showValues {
+			headers = append(headers, "Value")
+		}
+		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render(fmt.Sprintf("Secrets of %s:", appConfig.Name))
+		envTable := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				switch {
+				case row == 0:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("60")).Align(lipgloss.Center)
+				default:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
+				}
+			}).
+			Headers(headers...)
+
+		// the last env file defining a key is the one it comes from
+		sources := map[string]string{}
+		for _, file := range appConfig.Env.EnvFiles() {
+			fileEnv, _ := godotenv.Read(file)
+			for key := range fileEnv {
+				sources[key] = file
+			}
+		}
+		for _, key := range utils.EnvKeys(env) {
+			source := sources[key]
+			if slices.Contains(appConfig.Env.Managed, key) {
+				source = "sidekick env set"
+			}
+			row := []string{key, source}
+			if showValues {
+				row = append(row, env[key])
+			}
+			envTable.Row(row...)
+		}
+		for _, key := range utils.EnvKeys(appConfig.Env.Vars) {
+			row := []string{key, "sidekick.yml"}
+			if showValues {
+				row = append(row, appConfig.Env.Vars[key])
+			}
+			envTable.Row(row...)
+		}
+		fmt.Println(header)
+		fmt.Println(envTable)
+	},
+}
+
+func init() {
+	ListCmd.Flags().Bool("values", false, "Show the decrypted values too")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package envPull
+
+import (
+	"context"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var PullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Download a decrypted copy of the secrets of your application",
+	Long: `This command decrypts the env file of your application on your VPS and writes it to a local file for development.
+The file holds plain text secrets - keep it out of git.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fileName, _ := cmd.Flags().GetString("file")
+		force, _ := cmd.Flags().GetBool("force")
+
+		if utils.FileExists(fileName) && !force {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatalf("%s already exists - use --force to overwrite it", fileName)
+		}
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal("Unable to login to your VPS")
+		}
+
+		env, err := utils.ReadRemoteEnv(context.Background(), utils.NewSSHExecutor(sshClient), appConfig.Name)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal(err)
+		}
+		// with --force the comments and order of the existing file are kept
+		if err := utils.WriteEnvFile(fileName, env); err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Env"}).Infof("Wrote %d secrets to %s - keep it out of git", len(env), fileName)
+	},
+}
+
+func init() {
+	PullCmd.Flags().StringP("file", "f", ".env.local", "File to write the decrypted secrets to")
+	PullCmd.Flags().Bool("force", false, "Overwrite the file if it exists")
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package envSet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var SetCmd = &cobra.Command{
+	Use:   "set KEY=VALUE...",
+	Short: "Set secrets of your application on your VPS",
+	Long: `This command sets secrets in the encrypted env file of your application on your VPS.
+Keys set here are managed on the server - their values win over your local env file on the next deploy.
+With --local they are written to your last env file instead, which keeps them under its control.
+Use --restart to replace the running container so the new values take effect.`,
+	Example: "  sidekick env set API_KEY=abc123 DEBUG=false --restart",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		restart, _ := cmd.Flags().GetBool("restart")
+		local, _ := cmd.Flags().GetBool("local")
+
+		values := map[string]string{}
+		for _, arg := range args {
+			key, value, found := strings.Cut(arg, "=")
+			if !found || !envKeyPattern.MatchString(key) {
+				render.GetLogger(log.Options{Prefix: "Env"}).Fatalf("Invalid argument %q - use KEY=VALUE", arg)
+			}
+			values[key] = value
+		}
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		keys := utils.EnvKeys(values)
+		historyEntry := utils.NewHistoryEntry("env set")
+		err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick env set", func() error {
+			env, err := utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+			if err != nil {
+				return err
+			}
+			for key, value := range values {
+				env[key] = value
+			}
+			if err := utils.WriteRemoteEnv(ctx, executor, appConfig.Name, env); err != nil {
+				return err
+			}
+			historyEntry.EnvHash = utils.EnvHash(env)
+			if err := utils.WriteAppCompose(ctx, executor, appConfig, utils.EnvKeys(env)); err != nil {
+				return err
+			}
+
+			if local {
+				if err := utils.SetLocalEnv(appConfig.Env, values); err != nil {
+					return err
+				}
+				appConfig.Env.Unmanage(keys...)
+			} else {
+				appConfig.Env.Manage(keys...)
+			}
+			ymlData, _ := yaml.Marshal(&appConfig)
+			if err := os.WriteFile("./sidekick.yml", ymlData, 0644); err != nil {
+				return err
+			}
+
+			if restart {
+				var restartErr error
+				spinner.New().
+					Title("Restarting your application with the new values...").
+					Action(func() {
+						_, restartErr = utils.RestartWithEnv(ctx, executor, appConfig)
+					}).
+					Run()
+				return restartErr
+			}
+			return nil
+		})
+		historyEntry.Message = fmt.Sprintf("set %s", strings.Join(keys, ", "))
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal(err)
+		}
+
+		render.GetLogger(log.Options{Prefix: "Env"}).Infof("Set %s", strings.Join(keys, ", "))
+		if !restart {
+			render.GetLogger(log.Options{Prefix: "Env"}).Info("New values take effect on your next deploy or with sidekick env set --restart")
+		}
+	},
+}
+
+func init() {
+	SetCmd.Flags().Bool("restart", false, "Replace the running container so the new values take effect")
+	SetCmd.Flags().Bool("local", false, "Write the values to your last env file instead of managing them on the server")
+}
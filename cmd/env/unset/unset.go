@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package envUnset
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var UnsetCmd = &cobra.Command{
+	Use:   "unset KEY...",
+	Short: "Remove secrets of your application from your VPS",
+	Long: `This command removes secrets from the encrypted env file of your application on your VPS.
+Keys that are still in your local env files come back the next time deploy re-encrypts them, unless you use --local.`,
+	Example: "  sidekick env unset API_KEY --restart",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		restart, _ := cmd.Flags().GetBool("restart")
+		local, _ := cmd.Flags().GetBool("local")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		historyEntry := utils.NewHistoryEntry("env unset")
+		err = utils.WithLock(ctx, executor, appConfig.Name, "sidekick env unset", func() error {
+			env, err := utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+			if err != nil {
+				return err
+			}
+			for _, key := range args {
+				if _, ok := env[key]; !ok {
+					return fmt.Errorf("%s is not set on your VPS", key)
+				}
+				delete(env, key)
+			}
+			if err := utils.WriteRemoteEnv(ctx, executor, appConfig.Name, env); err != nil {
+				return err
+			}
+			historyEntry.EnvHash = utils.EnvHash(env)
+			if err := utils.WriteAppCompose(ctx, executor, appConfig, utils.EnvKeys(env)); err != nil {
+				return err
+			}
+
+			if local {
+				if err := utils.UnsetLocalEnv(appConfig.Env, args); err != nil {
+					return err
+				}
+			}
+			appConfig.Env.Unmanage(args...)
+			ymlData, _ := yaml.Marshal(&appConfig)
+			if err := os.WriteFile("./sidekick.yml", ymlData, 0644); err != nil {
+				return err
+			}
+
+			if restart {
+				var restartErr error
+				spinner.New().
+					Title("Restarting your application without those values...").
+					Action(func() {
+						_, restartErr = utils.RestartWithEnv(ctx, executor, appConfig)
+					}).
+					Run()
+				return restartErr
+			}
+			return nil
+		})
+		historyEntry.Message = fmt.Sprintf("unset %s", strings.Join(args, ", "))
+		historyEntry.Finish(err)
+		utils.AppendHistory(ctx, executor, appConfig.Name, *historyEntry)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Env"}).Fatal(err)
+		}
+
+		render.GetLogger(log.Options{Prefix: "Env"}).Infof("Removed %s", strings.Join(args, ", "))
+		if !restart {
+			render.GetLogger(log.Options{Prefix: "Env"}).Info("The running container keeps the old values until your next deploy or sidekick env unset --restart")
+		}
+	},
+}
+
+func init() {
+	UnsetCmd.Flags().Bool("restart", false, "Replace the running container so the change takes effect")
+	UnsetCmd.Flags().Bool("local", false, "Remove the keys from your local env files too")
+}
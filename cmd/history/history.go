@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var HistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show who deployed what to your application",
+	Long: `This command shows the deploys, previews and env changes recorded on your VPS for your application.
+Use --json to get the raw entries for tooling.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "History"}).Fatal("Unable to login to your VPS")
+		}
+
+		entries, err := utils.ReadHistory(context.Background(), utils.NewSSHExecutor(sshClient), appConfig.Name)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "History"}).Fatal(err)
+		}
+		entries = utils.LastHistory(entries, limit)
+
+		if asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(entries)
+			return
+		}
+
+		if len(entries) == 0 {
+			render.GetLogger(log.Options{Prefix: "History"}).Infof("Nothing recorded yet for %s", appConfig.Name)
+			return
+		}
+
+		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render(fmt.Sprintf("History of %s:", appConfig.Name))
+		historyTable := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				switch {
+				case row == 0:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("60")).Align(lipgloss.Center)
+				default:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
+				}
+			}).
+			Headers("Time", "Action", "Version", "Commit", "Image", "Env", "User", "Took", "Outcome")
+
+		// newest first
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			historyTable.Row(
+				entry.Time,
+				entry.Action,
+				entry.Version,
+				shorten(entry.GitSHA, 7),
+				shorten(entry.ImageDigest, 19),
+				shorten(entry.EnvHash, 8),
+				entry.User,
+				entry.Elapsed().Round(time.Second).String(),
+				entry.Outcome,
+			)
+		}
+		fmt.Println(header)
+		fmt.Println(historyTable)
+	},
+}
+
+func shorten(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value
+}
+
+func init() {
+	HistoryCmd.Flags().Bool("json", false, "Print the history as JSON")
+	HistoryCmd.Flags().IntP("limit", "n", 20, "Number of most recent entries to show, 0 for all")
+}
@@ -15,15 +15,14 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mightymoud/sidekick/render"
 	"github.com/mightymoud/sidekick/utils"
 	"github.com/spf13/cobra"
@@ -31,20 +30,20 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func stage1LocalReqs() error {
-	if _, err := exec.LookPath("sops"); err != nil {
-		cmd := exec.Command("brew", "install", "sops")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to install sops: %w", err)
-		}
+func stage1LocalReqs(executor utils.Executor) error {
+	if viper.GetString("publicKey") != "" && viper.GetString("secretKey") != "" {
+		return nil
 	}
-	if _, err := exec.LookPath("age"); err != nil {
-		cmd := exec.Command("brew", "install", "age")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to install age: %w", err)
+	return executor.Local("generate an age key", func() error {
+		publicKey, secretKey, err := utils.GenerateAgeKey()
+		if err != nil {
+			return err
 		}
-	}
-	return nil
+		viper.Set("publicKey", publicKey)
+		viper.Set("secretKey", secretKey)
+		render.RegisterSecret(secretKey)
+		return nil
+	})
 }
 
 func stage2Login(server string) (*ssh.Client, string, error) {
@@ -58,101 +57,55 @@ func stage2Login(server string) (*ssh.Client, string, error) {
 	return nil, "", fmt.Errorf("unable to establish SSH connection")
 }
 
-func stage3UserSetup(client *ssh.Client, loggedInUser string) error {
+func stage3UserSetup(ctx context.Context, executor utils.Executor, provisioner utils.Provisioner, loggedInUser string) error {
 	hasSidekickUser := true
-	outChan, _, err := utils.RunCommand(client, "id -u sidekick")
-	if err != nil {
+	output, err := executor.Query(ctx, "id -u sidekick")
+	if err != nil || strings.TrimSpace(output) == "" {
 		hasSidekickUser = false
-	} else {
-		output := <-outChan
-		if output == "" {
-			hasSidekickUser = false
-		}
 	}
 
 	if !hasSidekickUser && loggedInUser == "root" {
-		if err := utils.RunStage(client, utils.UsersetupStage); err != nil {
-			return err
+		for _, cmd := range provisioner.UserSetupStage().Commands {
+			if _, err := executor.Run(ctx, cmd); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func stage4VPSSetup(client *ssh.Client, p *tea.Program) error {
-	// get the linux distro
-	outChan, _, _ := utils.RunCommand(client, "grep '^ID=' /etc/os-release | awk -F'=' '{print $2}'")
-	linuxDistro := <-outChan
-	viper.Set("distro", linuxDistro)
-
-	// get docker platform id
-	cmdOutChan, _, _ := utils.RunCommand(client, "uname -m")
-	arch := <-cmdOutChan
-	if arch == "x86_64" {
-		viper.Set("platformID", "linux/amd64")
-	}
-	if arch == "aarch64" {
-		viper.Set("platformID", "linux/arm64")
-	}
-
-	if err := utils.RunCommandsWithTUIHook(client, utils.SetupStage.Commands, p); err != nil {
-		return err
-	}
-
-	publicKey := viper.GetString("publicKey")
-	secretKey := viper.GetString("secretKey")
+func stage4VPSSetup(ctx context.Context, executor utils.Executor, provisioner utils.Provisioner, p render.Output) error {
+	viper.Set("distro", provisioner.Distro)
+	viper.Set("platformID", provisioner.PlatformID())
 
-	if publicKey == "" || secretKey == "" {
-		cmd := exec.Command("age-keygen")
-		output, err := cmd.Output()
-		if err != nil {
-			return err
-		}
-		outStr := string(output)
-		lines := strings.Split(outStr, "\n")
-		if len(lines) >= 3 {
-			secretKey = lines[2]
-			parts := strings.Split(lines[1], ":")
-			if len(parts) > 1 {
-				publicKey = strings.ReplaceAll(parts[1], " ", "")
-			}
-		}
-		viper.Set("publicKey", publicKey)
-		viper.Set("secretKey", secretKey)
-	}
-	return nil
+	return utils.StreamCommands(ctx, executor, provisioner.SetupStage().Commands, p)
 }
 
-func stage5Docker(client *ssh.Client, p *tea.Program) error {
+func stage5Docker(ctx context.Context, executor utils.Executor, provisioner utils.Provisioner, p render.Output) error {
 	dockerReady := false
-	outChan, _, err := utils.RunCommand(client, `command -v docker &> /dev/null && command -v docker compose &> /dev/null && echo "1" || echo "0"`)
-	if err == nil {
-		output := <-outChan
-		if output == "1" {
-			dockerReady = true
-		}
+	output, err := executor.Query(ctx, `command -v docker &> /dev/null && command -v docker compose &> /dev/null && echo "1" || echo "0"`)
+	if err == nil && strings.TrimSpace(output) == "1" {
+		dockerReady = true
 	}
 
 	if !dockerReady {
-		if err := utils.RunCommandsWithTUIHook(client, utils.DockerStage.Commands, p); err != nil {
+		if err := utils.StreamCommands(ctx, executor, provisioner.DockerStage().Commands, p); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func stage6Traefik(client *ssh.Client, email string, p *tea.Program) error {
+func stage6Traefik(ctx context.Context, executor utils.Executor, email string, p render.Output) error {
 	traefikSetup := false
-	outChan, _, err := utils.RunCommand(client, `[ -d "traefik" ] && echo "1" || echo "0"`)
-	if err == nil {
-		output := <-outChan
-		if output == "1" {
-			traefikSetup = true
-		}
+	output, err := executor.Query(ctx, `[ -d "traefik" ] && echo "1" || echo "0"`)
+	if err == nil && strings.TrimSpace(output) == "1" {
+		traefikSetup = true
 	}
 
 	if !traefikSetup {
 		traefikStage := utils.GetTraefikStage(email)
-		if err := utils.RunCommandsWithTUIHook(client, traefikStage.Commands, p); err != nil {
+		if err := utils.StreamCommands(ctx, executor, traefikStage.Commands, p); err != nil {
 			return err
 		}
 	}
@@ -179,6 +132,7 @@ var InitCmd = &cobra.Command{
 		skipPromptsFlag, _ := cmd.Flags().GetBool("yes")
 		server, _ := cmd.Flags().GetString("server")
 		certEmail, _ := cmd.Flags().GetString("email")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 		if server == "" {
 			server = render.GenerateTextQuestion("Please enter the IPv4 Address of your VPS", "", "")
@@ -206,8 +160,16 @@ var InitCmd = &cobra.Command{
 		viper.Set("serverAddress", server)
 		viper.Set("certEmail", certEmail)
 
+		var plan *utils.Plan
+		if dryRun {
+			plan = utils.NewPlan("sidekick init")
+		}
+
+		ctx, cancel := utils.CancelContext()
+		defer cancel()
+
 		cmdStages := []render.Stage{
-			render.MakeStage("Setting up your local env", "Installed local requirements successfully", false),
+			render.MakeStage("Setting up your local env", "Local env ready", false),
 			render.MakeStage("Logging in to VPS", "Logged in successfully", false),
 			render.MakeStage("Adding user Sidekick", "User Sidekick added successfully", false),
 			render.MakeStage("Setting up VPS", "VPS setup successfully", true),
@@ -215,76 +177,103 @@ var InitCmd = &cobra.Command{
 			render.MakeStage("Setting up Traefik", "Traefik setup successfully", true),
 		}
 
-		p := tea.NewProgram(render.TuiModel{
+		p := render.NewOutput(render.TuiModel{
 			Stages:      cmdStages,
 			BannerMsg:   "Sidekick booting up! 🚀",
 			ActiveIndex: 0,
 			Quitting:    false,
 			AllDone:     false,
+			Cancel:      cancel,
 		})
 
 		utils.Login(server, "root")
 
 		go func() {
-			if err := stage1LocalReqs(); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Local requirements check failed: %s", err)})
+			orchestrator := utils.NewOrchestrator(ctx, p)
+
+			if err := stage1LocalReqs(utils.NewLocalExecutor(plan)); orchestrator.Failed(err, "Local setup failed") {
 				return
 			}
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
 			sshClient, loggedInUser, err := stage2Login(server)
-			if err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Login failed: %s", err)})
+			if orchestrator.Failed(err, "Login failed") {
+				return
+			}
+			provisioner, err := utils.DetectProvisioner(ctx, utils.NewExecutor(sshClient, plan))
+			if orchestrator.Failed(err, "") {
 				return
 			}
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage3UserSetup(sshClient, loggedInUser); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("User setup failed: %s", err)})
+			if err := stage3UserSetup(ctx, utils.NewExecutor(sshClient, plan), provisioner, loggedInUser); orchestrator.Failed(err, "User setup failed") {
 				return
 			}
 
+			var executor utils.Executor
 			sidekickClient, err := utils.Login(server, "sidekick")
-			if err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Failed to login as sidekick: %s", err)})
+			if err != nil && plan != nil {
+				// the sidekick user only exists once the plan is applied
+				executor = utils.NewDryRunExecutor(nil, plan, "sidekick")
+			} else if orchestrator.Failed(err, "Failed to login as sidekick") {
 				return
+			} else {
+				executor = utils.NewExecutor(sidekickClient, plan)
 			}
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage4VPSSetup(sidekickClient, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("VPS setup failed: %s", err)})
+			// provisioning steps are safe to repeat, only make sure an interrupted package manager isn't left broken
+			orchestrator.OnCancel(func(ctx context.Context) string {
+				output, err := executor.Run(ctx, provisioner.RollbackScript())
+				if err != nil {
+					return fmt.Sprintf("Rollback failed, please check your server manually: %s", err)
+				}
+				return strings.TrimSpace(output)
+			})
+
+			if err := stage4VPSSetup(ctx, executor, provisioner, p); orchestrator.Failed(err, "VPS setup failed") {
 				return
 			}
+			// the VPS gets an age key of its own, running init again keeps it
+			if err := utils.EnsureAgeKey(ctx, executor); orchestrator.Failed(err, "Storing the age key failed") {
+				return
+			}
+			// nothing to read yet in a dry run of the first init
+			serverPublicKey, err := utils.ServerPublicKey(ctx, executor)
+			if !executor.DryRun() && orchestrator.Failed(err, "") {
+				return
+			}
+			viper.Set("serverPublicKey", serverPublicKey)
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage5Docker(sidekickClient, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Docker setup failed: %s", err)})
+			if err := stage5Docker(ctx, executor, provisioner, p); orchestrator.Failed(err, "Docker setup failed") {
 				return
 			}
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err := stage6Traefik(sidekickClient, certEmail, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Traefik setup failed: %s", err)})
+			if err := stage6Traefik(ctx, executor, certEmail, p); orchestrator.Failed(err, "Traefik setup failed") {
 				return
 			}
 
-			if err := viper.WriteConfig(); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Failed to write config: %s", err)})
+			if err := executor.Local("write ~/.config/sidekick/default.yaml", viper.WriteConfig); orchestrator.Failed(err, "Failed to write config") {
 				return
 			}
 
-			p.Send(render.AllDoneMsg{Message: "VPS Setup Done in " + time.Since(start).Round(time.Second).String() + "," + "\n" + "Your VPS is ready! You can now run Sidekick launch in your app folder"})
+			orchestrator.Done(plan.DoneMessage("VPS Setup Done in " + time.Since(start).Round(time.Second).String() + "," + "\n" + "Your VPS is ready! You can now run Sidekick launch in your app folder"))
 		}()
 
-		if _, err := p.Run(); err != nil {
-			fmt.Println("Error running program:", err)
+		if err := p.Run(); err != nil {
+			if !errors.Is(err, render.ErrStageFailed) {
+				fmt.Println("Error running program:", err)
+			}
 			os.Exit(1)
 		}
+		plan.Print(os.Stdout)
 	},
 }
 
@@ -319,4 +308,5 @@ func init() {
 	InitCmd.Flags().StringP("server", "s", "", "Set the IP address of your Server")
 	InitCmd.Flags().StringP("email", "e", "", "An email address to be used for SSL certs")
 	InitCmd.Flags().BoolP("yes", "y", false, "Skip all validation prompts")
+	InitCmd.Flags().Bool("dry-run", false, "Show what init would change on your VPS without changing anything")
 }
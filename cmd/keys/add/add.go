@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package keysAdd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var agePublicKeyPattern = regexp.MustCompile(`^age1[02-9ac-hj-np-z]{58}$`)
+
+var AddCmd = &cobra.Command{
+	Use:     "add NAME PUBLIC_KEY",
+	Short:   "Give a teammate access to the secrets on your VPS",
+	Long:    `This command adds an age public key to the recipients in your config and re-encrypts the env file of every app and preview on your VPS for it.`,
+	Example: "  sidekick keys add alice age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, publicKey := args[0], args[1]
+		if !agePublicKeyPattern.MatchString(publicKey) {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatalf("%s is not an age public key", publicKey)
+		}
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		recipients := utils.Recipients()
+		if existing, ok := recipients[name]; ok && existing != publicKey {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatalf("%s already has a key - remove it first", name)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		var envFiles []utils.RemoteEnvFile
+		spinner.New().
+			Title("Re-encrypting your secrets...").
+			Action(func() {
+				err = utils.WithEnvLocks(ctx, executor, "sidekick keys add", func() error {
+					if viper.GetString("serverPublicKey") == "" {
+						serverPublicKey, keyErr := utils.ServerPublicKey(ctx, executor)
+						if keyErr != nil {
+							return keyErr
+						}
+						viper.Set("serverPublicKey", serverPublicKey)
+					}
+					var readErr error
+					envFiles, readErr = utils.ReadAllRemoteEnvs(ctx, executor)
+					if readErr != nil {
+						return readErr
+					}
+					recipients[name] = publicKey
+					viper.Set("recipients", recipients)
+					return utils.WriteAllRemoteEnvs(ctx, executor, envFiles, nil)
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal(err)
+		}
+		if err := viper.WriteConfig(); err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal(err)
+		}
+
+		for _, appName := range utils.AppsOf(envFiles) {
+			historyEntry := utils.NewHistoryEntry("keys add")
+			historyEntry.Message = fmt.Sprintf("added %s", name)
+			historyEntry.Finish(nil)
+			utils.AppendHistory(ctx, executor, appName, *historyEntry)
+		}
+		render.GetLogger(log.Options{Prefix: "Keys"}).Infof("%s can now decrypt %d env files", name, len(envFiles))
+	},
+}
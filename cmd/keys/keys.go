@@ -0,0 +1,37 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package keys
+
+import (
+	keysAdd "github.com/mightymoud/sidekick/cmd/keys/add"
+	keysList "github.com/mightymoud/sidekick/cmd/keys/list"
+	keysRemove "github.com/mightymoud/sidekick/cmd/keys/remove"
+	keysRotate "github.com/mightymoud/sidekick/cmd/keys/rotate"
+	"github.com/spf13/cobra"
+)
+
+var KeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage who can decrypt the secrets on your VPS",
+	Long: `Env files are encrypted with age for your VPS, for this machine and for every recipient listed in your config.
+To give a teammate access, have them run sidekick init with the same server to get their own key, then add the public key they get from sidekick keys list.`,
+}
+
+func init() {
+	KeysCmd.AddCommand(keysList.ListCmd)
+	KeysCmd.AddCommand(keysAdd.AddCmd)
+	KeysCmd.AddCommand(keysRemove.RemoveCmd)
+	KeysCmd.AddCommand(keysRotate.RotateCmd)
+}
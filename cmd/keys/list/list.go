@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package keysList
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the age recipients your secrets are encrypted for",
+	Long:    `This command lists the public keys env files are encrypted for. Send the key of this machine to a teammate so they can add you.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+
+		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render("Recipients of your secrets:")
+		keysTable := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				switch {
+				case row == 0:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("60")).Align(lipgloss.Center)
+				default:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
+				}
+			}).
+			Headers("Name", "Public Key")
+
+		keysTable.Row("this machine", viper.GetString("publicKey"))
+		if serverPublicKey := viper.GetString("serverPublicKey"); serverPublicKey != "" {
+			keysTable.Row("VPS", serverPublicKey)
+		}
+		recipients := utils.Recipients()
+		names := make([]string, 0, len(recipients))
+		for name := range recipients {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			keysTable.Row(name, recipients[name])
+		}
+		fmt.Println(header)
+		fmt.Println(keysTable)
+	},
+}
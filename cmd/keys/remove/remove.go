@@ -0,0 +1,89 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package keysRemove
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var RemoveCmd = &cobra.Command{
+	Use:     "remove NAME",
+	Aliases: []string{"rm"},
+	Short:   "Revoke the access of a teammate to the secrets on your VPS",
+	Long: `This command removes a recipient from your config and re-encrypts the env file of every app and preview on your VPS without it.
+They could have kept a decrypted copy, so rotate the secrets themselves too.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		recipients := utils.Recipients()
+		publicKey, ok := recipients[name]
+		if !ok {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatalf("%s is not one of your recipients", name)
+		}
+		if publicKey == viper.GetString("publicKey") || publicKey == viper.GetString("serverPublicKey") {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatalf("%s is the key of this machine or of your VPS - use sidekick keys rotate instead", name)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		var envFiles []utils.RemoteEnvFile
+		spinner.New().
+			Title("Re-encrypting your secrets...").
+			Action(func() {
+				err = utils.WithEnvLocks(ctx, executor, "sidekick keys remove", func() error {
+					var readErr error
+					envFiles, readErr = utils.ReadAllRemoteEnvs(ctx, executor)
+					if readErr != nil {
+						return readErr
+					}
+					delete(recipients, name)
+					viper.Set("recipients", recipients)
+					return utils.WriteAllRemoteEnvs(ctx, executor, envFiles, []string{publicKey})
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal(err)
+		}
+		if err := viper.WriteConfig(); err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal(err)
+		}
+
+		for _, appName := range utils.AppsOf(envFiles) {
+			historyEntry := utils.NewHistoryEntry("keys remove")
+			historyEntry.Message = fmt.Sprintf("removed %s", name)
+			historyEntry.Finish(nil)
+			utils.AppendHistory(ctx, executor, appName, *historyEntry)
+		}
+		render.GetLogger(log.Options{Prefix: "Keys"}).Infof("%s can no longer decrypt your %d env files", name, len(envFiles))
+	},
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package keysRotate
+
+import (
+	"context"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var RotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the age key of your VPS",
+	Long: `This command generates a new age key for your VPS and re-encrypts the env file of every app and preview on it.
+The new key is generated on your VPS and never leaves it, the old one is only kept to restore older backups.
+If this machine shares its key with the VPS, as it did on servers set up by older versions, it gets a key of its own.
+Teammates keep their own keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		var envFiles []utils.RemoteEnvFile
+		spinner.New().
+			Title("Rotating the key of your VPS...").
+			Action(func() {
+				err = utils.WithEnvLocks(ctx, executor, "sidekick keys rotate", func() error {
+					var readErr error
+					envFiles, readErr = utils.ReadAllRemoteEnvs(ctx, executor)
+					if readErr != nil {
+						return readErr
+					}
+					return utils.RotateServerKey(ctx, executor, envFiles)
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Keys"}).Fatal(err)
+		}
+
+		for _, appName := range utils.AppsOf(envFiles) {
+			historyEntry := utils.NewHistoryEntry("keys rotate")
+			historyEntry.Message = "rotated the VPS key"
+			historyEntry.Finish(nil)
+			utils.AppendHistory(ctx, executor, appName, *historyEntry)
+		}
+		render.GetLogger(log.Options{Prefix: "Keys"}).Infof("Rotated the key of your VPS and re-encrypted %d env files", len(envFiles))
+	},
+}
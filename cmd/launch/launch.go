@@ -16,15 +16,14 @@ package launch
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/log"
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/client"
@@ -96,112 +95,97 @@ func stage1() (*ssh.Client, error) {
 	return sshClient, err
 }
 
-func stage2(appName string, p *tea.Program) error {
-	cwd, _ := os.Getwd()
-	cwdTar, err := utils.TarDirectoryToReader(cwd)
-	if err != nil {
-		return err
-	}
+func stage2(ctx context.Context, executor utils.Executor, appName string, p render.Output) error {
+	return executor.Local(fmt.Sprintf("docker build --tag %s:latest --platform=%s .", appName, viper.GetString("platformID")), func() error {
+		cwd, _ := os.Getwd()
+		cwdTar, err := utils.TarDirectoryToReader(cwd)
+		if err != nil {
+			return err
+		}
 
-	dockerClient, err := getDockerClient()
-	if err != nil {
-		return err
-	}
+		dockerClient, err := getDockerClient()
+		if err != nil {
+			return err
+		}
 
-	ctx := context.Background()
-	resp, err := dockerClient.ImageBuild(ctx, cwdTar, build.ImageBuildOptions{
-		Tags:     []string{fmt.Sprintf("%s:latest", appName)},
-		Platform: viper.GetString("platformID"),
+		resp, err := dockerClient.ImageBuild(ctx, cwdTar, build.ImageBuildOptions{
+			Tags:     []string{fmt.Sprintf("%s:latest", appName)},
+			Platform: viper.GetString("platformID"),
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		render.SendDockerBuildLogsToTUI(resp.Body, p)
+		time.Sleep(time.Millisecond * 100)
+		return nil
 	})
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	render.SendDockerBuildLogsToTUI(resp.Body, p)
-	time.Sleep(time.Millisecond * 100)
-	return nil
 }
 
-func stage3(appName string, p *tea.Program) error {
-	ctx := context.Background()
-	imageReader, err := dockerClient.ImageSave(ctx, []string{fmt.Sprintf("%s:latest", appName)})
-	if err != nil {
-		return err
-	}
-	defer imageReader.Close()
-
+func stage3(ctx context.Context, executor utils.Executor, appName string, p render.Output) error {
 	imgFileName := fmt.Sprintf("%s-latest.tar", appName)
-	outFile, err := os.Create(imgFileName)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
+	return executor.Local(fmt.Sprintf("docker save -o %s %s:latest", imgFileName, appName), func() error {
+		imageReader, err := dockerClient.ImageSave(ctx, []string{fmt.Sprintf("%s:latest", appName)})
+		if err != nil {
+			return err
+		}
+		defer imageReader.Close()
 
-	if _, err := io.Copy(outFile, imageReader); err != nil {
-		return err
-	}
+		outFile, err := os.Create(imgFileName)
+		if err != nil {
+			return err
+		}
+		defer outFile.Close()
 
-	return nil
+		if _, err := io.Copy(outFile, imageReader); err != nil {
+			return err
+		}
+
+		return nil
+	})
 }
 
-func stage4(sshClient *ssh.Client, appName string, p *tea.Program) error {
-	_, _, sessionErr := utils.RunCommand(sshClient, fmt.Sprintf("mkdir %s", appName))
-	if sessionErr != nil {
-		p.Send(render.ErrorMsg{ErrorStr: sessionErr.Error()})
+func stage4(ctx context.Context, executor utils.Executor, appName string, p render.Output) error {
+	if _, sessionErr := executor.Run(ctx, fmt.Sprintf("mkdir -p %s", appName)); sessionErr != nil {
+		return sessionErr
 	}
 	imgFileName := fmt.Sprintf("%s-latest.tar", appName)
-	remoteDist := fmt.Sprintf("%s@%s:./%s", "sidekick", viper.GetString("serverAddress"), appName)
-	imgMoveCmd := exec.Command("scp", "-C", imgFileName, remoteDist)
-	imgMoveCmdErrorPipe, _ := imgMoveCmd.StderrPipe()
-	go render.SendLogsToTUI(imgMoveCmdErrorPipe, p)
-
-	if imgMovCmdErr := imgMoveCmd.Run(); imgMovCmdErr != nil {
+	if imgMovCmdErr := executor.Upload(ctx, imgFileName, fmt.Sprintf("./%s", appName), p); imgMovCmdErr != nil {
 		return imgMovCmdErr
 	}
 	defer os.Remove(imgFileName)
-	dockerLoadOutChan, _, sessionErr := utils.RunCommand(sshClient, fmt.Sprintf("cd %s && docker load -i %s && rm %s", appName, imgFileName, imgFileName))
-	go func() {
-		p.Send(render.LogMsg{LogLine: <-dockerLoadOutChan + "\n"})
-		time.Sleep(time.Millisecond * 50)
-	}()
+	dockerLoadOut, sessionErr := executor.Run(ctx, fmt.Sprintf("cd %s && docker load -i %s && rm %s", appName, imgFileName, imgFileName))
 	if sessionErr != nil {
-		time.Sleep(time.Millisecond * 100)
-		p.Send(render.ErrorMsg{ErrorStr: sessionErr.Error()})
+		return sessionErr
 	}
+	p.Send(render.LogMsg{LogLine: dockerLoadOut})
 	return nil
 }
 
-func stage5(sshClient *ssh.Client, appName string, appPort string, appDomain string, hasEnvFile bool, envFileName string, envFileChecksum string, p *tea.Program) error {
-	rsyncCmd := exec.Command("rsync", "docker-compose.yaml", fmt.Sprintf("%s@%s:%s", "sidekick", viper.GetString("serverAddress"), fmt.Sprintf("./%s", appName)))
-	rsyncCmErr := rsyncCmd.Run()
-	if rsyncCmErr != nil {
+func stage5(ctx context.Context, executor utils.Executor, appName string, appPort string, appDomain string, hasEnvFile bool, envFileName string, envFileChecksum string, p render.Output) error {
+	if rsyncCmErr := executor.Upload(ctx, "docker-compose.yaml", fmt.Sprintf("./%s", appName), p); rsyncCmErr != nil {
 		return rsyncCmErr
 	}
 
 	if hasEnvFile {
-		encryptSync := exec.Command("rsync", "encrypted.env", fmt.Sprintf("%s@%s:%s", "sidekick", viper.GetString("serverAddress"), fmt.Sprintf("./%s", appName)))
-		encryptSyncErr := encryptSync.Run()
-		if encryptSyncErr != nil {
+		if encryptSyncErr := executor.Upload(ctx, "encrypted.env", fmt.Sprintf("./%s", appName), p); encryptSyncErr != nil {
 			return encryptSyncErr
 		}
 
-		runAppCmdOutChan, _, sessionErr1 := utils.RunCommand(sshClient, fmt.Sprintf(`cd %s && export SOPS_AGE_KEY=%s && sops exec-env encrypted.env 'docker compose -p sidekick up -d'`, appName, viper.GetString("secretKey")))
-		go func() {
-			p.Send(render.LogMsg{LogLine: <-runAppCmdOutChan + "\n"})
-			time.Sleep(time.Millisecond * 50)
-		}()
+		if err := utils.EnsureAgeKey(ctx, executor); err != nil {
+			return err
+		}
+		runAppCmdOut, sessionErr1 := executor.Run(ctx, fmt.Sprintf("cd %s && %s", appName, utils.SopsExecEnv("encrypted.env", "docker compose -p sidekick up -d")))
 		if sessionErr1 != nil {
 			return sessionErr1
 		}
+		p.Send(render.LogMsg{LogLine: runAppCmdOut})
 	} else {
-		runAppCmdOutChan, _, sessionErr1 := utils.RunCommand(sshClient, fmt.Sprintf(`cd %s && docker compose -p sidekick up -d`, appName))
-		go func() {
-			p.Send(render.LogMsg{LogLine: <-runAppCmdOutChan + "\n"})
-			time.Sleep(time.Millisecond * 50)
-		}()
+		runAppCmdOut, sessionErr1 := executor.Run(ctx, fmt.Sprintf(`cd %s && docker compose -p sidekick up -d`, appName))
 		if sessionErr1 != nil {
 			return sessionErr1
 		}
+		p.Send(render.LogMsg{LogLine: runAppCmdOut})
 	}
 
 	portNumber, err := strconv.ParseUint(appPort, 0, 64)
@@ -210,7 +194,7 @@ func stage5(sshClient *ssh.Client, appName string, appPort string, appDomain str
 	}
 	envConfig := utils.SidekickAppEnvConfig{}
 	if hasEnvFile {
-		envConfig.File = envFileName
+		envConfig.Files = []string{envFileName}
 		envConfig.Hash = envFileChecksum
 	}
 	// save app config in same folder
@@ -222,9 +206,10 @@ func stage5(sshClient *ssh.Client, appName string, appPort string, appDomain str
 		CreatedAt: time.Now().Format(time.UnixDate),
 		Env:       envConfig,
 	}
-	ymlData, _ := yaml.Marshal(&sidekickAppConfig)
-	os.WriteFile("./sidekick.yml", ymlData, 0644)
-	return nil
+	return executor.Local("write sidekick.yml", func() error {
+		ymlData, _ := yaml.Marshal(&sidekickAppConfig)
+		return os.WriteFile("./sidekick.yml", ymlData, 0644)
+	})
 }
 
 var LaunchCmd = &cobra.Command{
@@ -236,11 +221,32 @@ var LaunchCmd = &cobra.Command{
 
 		appPort := prelude()
 
+		var plan *utils.Plan
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			plan = utils.NewPlan("sidekick launch")
+		}
+
 		appName := render.GenerateTextQuestion("Please enter your app url friendly app name", "", "will identify your app containers")
 		appPort = render.GenerateTextQuestion("Please enter the port at which the app receives request", appPort, "")
 		appDomain := render.GenerateTextQuestion("Please enter the domain to point the app to", fmt.Sprintf("%s.%s.sslip.io", appName, viper.Get("serverAddress").(string)), "must point to your VPS address")
 		envFileName := render.GenerateTextQuestion("Please enter which env file you would like to load", ".env", "")
 
+		if err := utils.ValidateDomain(utils.SidekickAppDomainConfig{Host: appDomain}); err != nil {
+			render.GetLogger(log.Options{Prefix: "Launch"}).Fatal(err)
+		}
+		if skipDNSCheck, _ := cmd.Flags().GetBool("skip-dns-check"); !skipDNSCheck {
+			sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+			if err != nil {
+				render.GetLogger(log.Options{Prefix: "Launch"}).Fatal("Unable to login to your VPS")
+			}
+			// the VPS may have more addresses than the one we log in with, like an IPv6 one
+			serverIPs := utils.ServerIPs(context.Background(), utils.NewSSHExecutor(sshClient), viper.GetString("serverAddress"))
+			sshClient.Close()
+			if err := utils.PreflightDNS(context.Background(), []string{appDomain}, serverIPs); err != nil {
+				render.GetLogger(log.Options{Prefix: "DNS"}).Fatal(err)
+			}
+		}
+
 		hasEnvFile := false
 		dockerEnvProperty := []string{}
 		envFileChecksum := ""
@@ -256,34 +262,11 @@ var LaunchCmd = &cobra.Command{
 			render.GetLogger(log.Options{Prefix: "Env File"}).Info("Not Detected - Skipping env parsing")
 		}
 
-		// make a docker service
-		imageName := appName
-		newService := utils.DockerService{
-			Image:   imageName,
-			Restart: "unless-stopped",
-			Labels: []string{
-				"traefik.enable=true",
-				fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", appName, appDomain),
-				fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%s", appName, appPort),
-				fmt.Sprintf("traefik.http.routers.%s.tls=true", appName),
-				fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=default", appName),
-				"traefik.docker.network=sidekick",
-			},
-			Environment: dockerEnvProperty,
-			Networks: []string{
-				"sidekick",
-			},
-		}
-		newDockerCompose := utils.DockerComposeFile{
-			Services: map[string]utils.DockerService{
-				appName: newService,
-			},
-			Networks: map[string]utils.DockerNetwork{
-				"sidekick": {
-					External: true,
-				},
-			},
+		portNumber, err := strconv.ParseUint(appPort, 0, 64)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Launch"}).Fatalf("%s is not a valid port", appPort)
 		}
+		newDockerCompose := utils.AppComposeFile(utils.SidekickAppConfig{Name: appName, Url: appDomain, Port: portNumber}, dockerEnvProperty)
 		dockerComposeFile, err := yaml.Marshal(&newDockerCompose)
 		if err != nil {
 			fmt.Printf("Error marshalling YAML: %v\n", err)
@@ -296,6 +279,9 @@ var LaunchCmd = &cobra.Command{
 		}
 		defer os.Remove("docker-compose.yaml")
 
+		ctx, cancel := utils.CancelContext()
+		defer cancel()
+
 		cmdStages := []render.Stage{
 			render.MakeStage("Validating connection with VPS", "VPS is reachable", false),
 			render.MakeStage("Building latest docker image of your app", "Latest docker image built", true),
@@ -303,54 +289,86 @@ var LaunchCmd = &cobra.Command{
 			render.MakeStage("Moving image to your server", "Image moved and loaded successfully", false),
 			render.MakeStage("Setting up your application", "Application setup successfully", false),
 		}
-		p := tea.NewProgram(render.TuiModel{
+		p := render.NewOutput(render.TuiModel{
 			Stages:      cmdStages,
 			BannerMsg:   "Launching your application on your VPS 🚀",
 			ActiveIndex: 0,
 			Quitting:    false,
 			AllDone:     false,
+			Cancel:      cancel,
 		})
 
 		go func() {
+			orchestrator := utils.NewOrchestrator(ctx, p)
+
 			sshClient, err := stage1()
-			if err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: "Something went wrong logging in to your VPS"})
+			if orchestrator.Failed(err, "Something went wrong logging in to your VPS") {
+				return
+			}
+			executor := utils.NewExecutor(sshClient, plan)
+			if err := orchestrator.Lock(executor, appName, "sidekick launch"); orchestrator.Failed(err, "") {
+				return
 			}
+			historyEntry := utils.NewHistoryEntry("launch")
+			historyEntry.Version = "V1"
+			historyEntry.Image = fmt.Sprintf("%s:latest", appName)
+			historyEntry.EnvHash = envFileChecksum
+			orchestrator.Record(executor, appName, historyEntry)
 
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err = stage2(appName, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Something went wrong building your docker image: %s", err)})
+			imgFileName := fmt.Sprintf("%s-latest.tar", appName)
+			orchestrator.OnCancel(func(ctx context.Context) string {
+				os.Remove(imgFileName)
+				return utils.RollbackService(ctx, executor, appName, appName, 0, fmt.Sprintf("rm -f %s", imgFileName))
+			})
+
+			if err = stage2(ctx, executor, appName, p); orchestrator.Failed(err, "Something went wrong building your docker image") {
+				return
 			}
 
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err = stage3(appName, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Something went wrong saving docker image to a file: %s", err)})
+			if err = stage3(ctx, executor, appName, p); orchestrator.Failed(err, "Something went wrong saving docker image to a file") {
+				return
 			}
 
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err = stage4(sshClient, appName, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Something went wrong moving the image to your VPS: %s", err)})
+			if err = stage4(ctx, executor, appName, p); orchestrator.Failed(err, "Something went wrong moving the image to your VPS") {
+				return
 			}
 
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			if err = stage5(sshClient, appName, appPort, appDomain, hasEnvFile, envFileName, envFileChecksum, p); err != nil {
-				p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("Something went wrong booting up your app: %s", err)})
+			if err = stage5(ctx, executor, appName, appPort, appDomain, hasEnvFile, envFileName, envFileChecksum, p); orchestrator.Failed(err, "Something went wrong booting up your app") {
+				return
+			}
+
+			if !executor.DryRun() {
+				if err := utils.VerifyCertificate(ctx, executor, appDomain); err != nil {
+					p.Send(render.LogMsg{LogLine: err.Error()})
+				}
 			}
 
-			p.Send(render.AllDoneMsg{Message: "🚀 Deployed successfully in " + time.Since(start).Round(time.Second).String() + ".\n" + "😎 View your app at https://" + appDomain})
+			orchestrator.Done(plan.DoneMessage("🚀 Deployed successfully in " + time.Since(start).Round(time.Second).String() + ".\n" + "😎 View your app at https://" + appDomain))
 		}()
 
-		if _, err := p.Run(); err != nil {
-			fmt.Println("Error running program:", err)
+		if err := p.Run(); err != nil {
+			if !errors.Is(err, render.ErrStageFailed) {
+				fmt.Println("Error running program:", err)
+			}
 			os.Exit(1)
 		}
+		plan.Print(os.Stdout)
 	},
 }
+
+func init() {
+	LaunchCmd.Flags().Bool("dry-run", false, "Show what launch would change on your VPS without changing anything")
+	LaunchCmd.Flags().Bool("skip-dns-check", false, "Launch even if the domain doesn't point to your VPS yet")
+}
@@ -0,0 +1,31 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lock
+
+import (
+	lockStatus "github.com/mightymoud/sidekick/cmd/lock/status"
+	"github.com/spf13/cobra"
+)
+
+var LockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect the deploy lock of your application",
+	Long: `Sidekick takes a lock on your server while deploy, launch or preview are running for an app.
+This prevents two people from deploying the same app at the same time.`,
+}
+
+func init() {
+	LockCmd.AddCommand(lockStatus.StatusCmd)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package lockStatus
+
+import (
+	"context"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show who holds the deploy lock of your application",
+	Long:  `This command shows if a deploy, launch or preview is currently running for your application and who started it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatal("Unable to login to your VPS")
+		}
+
+		lock, err := utils.ReadLock(context.Background(), utils.NewSSHExecutor(sshClient), appConfig.Name)
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatal(err)
+		}
+		if lock == nil {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Infof("Free - nothing is running for %s", appConfig.Name)
+			return
+		}
+
+		logger := render.GetLogger(log.Options{Prefix: "Lock"})
+		logger.Warnf("Held - %s is running for %s", lock.Command, appConfig.Name)
+		logger.Infof("Owner: %s", lock.Owner)
+		logger.Infof("Host: %s", lock.Host)
+		logger.Infof("PID: %d", lock.PID)
+		logger.Infof("Since: %s", lock.AcquiredAt)
+	},
+}
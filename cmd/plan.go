@@ -0,0 +1,55 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var PlanCmd = &cobra.Command{
+	Use:   "plan [command]",
+	Short: "Show what a command would change on your VPS without changing anything",
+	Long: `This command runs launch, deploy, preview or init as a dry run.
+It prints the generated compose file with its Traefik labels, the files to upload and the commands that would run over SSH, and diffs the compose file against the one on your VPS.
+sidekick plan deploy is the same as sidekick deploy --dry-run.`,
+	Example:            "  sidekick plan deploy\n  sidekick plan init --server 1.2.3.4 --email me@example.com",
+	ValidArgs:          []string{"launch", "deploy", "preview", "init"},
+	Args:               cobra.MinimumNArgs(1),
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if args[0] == "-h" || args[0] == "--help" {
+			cmd.Help()
+			return
+		}
+		target, targetArgs, err := rootCmd.Find(args)
+		if err != nil || target == rootCmd || target.Flags().Lookup("dry-run") == nil {
+			log.Fatalf("%s can't be planned - use one of launch, deploy, preview or init", args[0])
+		}
+		if err := target.ParseFlags(targetArgs); err != nil {
+			log.Fatalf("%s", err)
+		}
+		if err := rootCmd.PersistentPreRunE(target, target.Flags().Args()); err != nil {
+			log.Fatalf("%s", err)
+		}
+		target.Flags().Set("dry-run", "true")
+		target.Run(target, target.Flags().Args())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(PlanCmd)
+}
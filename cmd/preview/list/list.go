@@ -15,6 +15,7 @@ limitations under the License.
 package previewList
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/mightymoud/sidekick/render"
 	"github.com/mightymoud/sidekick/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // listCmd represents the list command
@@ -42,6 +44,28 @@ var ListCmd = &cobra.Command{
 			render.GetLogger(log.Options{Prefix: "Preview Envs"}).Info("Not Found in current project")
 			os.Exit(0)
 		}
+
+		// the secret lives in the encrypted env of the app, so only log in if a preview needs it
+		secret := ""
+		for _, preview := range appConfig.PreviewEnvs {
+			if preview.Auth == "" || preview.Auth == utils.PreviewAuthNone {
+				continue
+			}
+			if configErr := utils.ViperInit(); configErr != nil {
+				render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+			}
+			sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+			if err != nil {
+				render.GetLogger(log.Options{Prefix: "Preview Envs"}).Fatal("Unable to login to your VPS")
+			}
+			auth, err := utils.ReadPreviewAuth(context.Background(), utils.NewSSHExecutor(sshClient), appConfig.Name, preview.Auth)
+			if err != nil {
+				render.GetLogger(log.Options{Prefix: "Preview Envs"}).Fatal(err)
+			}
+			secret = auth.Secret
+			break
+		}
+
 		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render("Currently running preview envs:")
 		tableString := table.New().
 			Border(lipgloss.RoundedBorder()).
@@ -54,12 +78,13 @@ var ListCmd = &cobra.Command{
 					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
 				}
 			}).
-			Headers("Commit", "Image", "Deployed At", "URL")
+			Headers("Commit", "Image", "Deployed At", "URL", "Auth")
 
 		hashSlice := []huh.Option[string]{}
 		for v := range appConfig.PreviewEnvs {
 			hashSlice = append(hashSlice, huh.NewOption(v, v))
-			tableString.Row(v, appConfig.PreviewEnvs[v].Image, appConfig.PreviewEnvs[v].CreatedAt, appConfig.PreviewEnvs[v].Url)
+			auth := utils.PreviewAuth{Mode: appConfig.PreviewEnvs[v].Auth, Secret: secret}
+			tableString.Row(v, appConfig.PreviewEnvs[v].Image, appConfig.PreviewEnvs[v].CreatedAt, appConfig.PreviewEnvs[v].Url, auth.String())
 		}
 		fmt.Println(header)
 		fmt.Println(tableString)
@@ -15,13 +15,14 @@ limitations under the License.
 package preview
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/log"
 	previewList "github.com/mightymoud/sidekick/cmd/preview/list"
 	previewRemove "github.com/mightymoud/sidekick/cmd/preview/remove"
@@ -73,6 +74,14 @@ var PreviewCmd = &cobra.Command{
 		}
 		deployHash := strings.TrimSuffix(string(hashOutput), "\n")
 
+		var plan *utils.Plan
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			plan = utils.NewPlan("sidekick preview")
+		}
+
+		ctx, cancel := utils.CancelContext()
+		defer cancel()
+
 		cmdStages := []render.Stage{
 			render.MakeStage("Validating connection with VPS", "VPS is reachable", false),
 			render.MakeStage("Building latest docker image of your app", "Latest docker image built", true),
@@ -80,186 +89,203 @@ var PreviewCmd = &cobra.Command{
 			render.MakeStage("Moving image to your server", "Image moved and loaded successfully", false),
 			render.MakeStage("Deploying a preview env of your application", "Preview env setup successfully", false),
 		}
-		p := tea.NewProgram(render.TuiModel{
+		p := render.NewOutput(render.TuiModel{
 			Stages:      cmdStages,
 			BannerMsg:   "Deploying a preview env of your app 😎",
 			ActiveIndex: 0,
 			Quitting:    false,
 			AllDone:     false,
+			Cancel:      cancel,
 		})
 
 		go func() {
+			orchestrator := utils.NewOrchestrator(ctx, p)
+
 			sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
-			if err != nil {
-				p.Send(render.ErrorMsg{})
+			if orchestrator.Failed(err, "Something went wrong logging in to your VPS") {
+				return
 			}
+			executor := utils.NewExecutor(sshClient, plan)
+			if err := orchestrator.Lock(executor, appConfig.Name, "sidekick preview"); orchestrator.Failed(err, "") {
+				return
+			}
+			historyEntry := utils.NewHistoryEntry("preview")
+			historyEntry.Version = deployHash
+			historyEntry.Image = fmt.Sprintf("%s:%s", appConfig.Name, deployHash)
+			orchestrator.Record(executor, appConfig.Name, historyEntry)
 			p.Send(render.NextStageMsg{})
 
-			dockerEnvProperty := []string{}
-			envFileChecksum := ""
-			if appConfig.Env.File != "" {
-				envErr := utils.HandleEnvFile(appConfig.Env.File, &dockerEnvProperty, &envFileChecksum)
-				if envErr != nil {
-					panic(envErr)
+			previewAuth, err := utils.EnsurePreviewAuth(ctx, executor, &appConfig)
+			if orchestrator.Failed(err, "Something went wrong setting up the preview auth") {
+				return
+			}
+
+			// previews get the secrets of the app with the preview overrides on top
+			previewEnv := map[string]string{}
+			if appConfig.Env.HasPreviewEnv() {
+				serverEnv := map[string]string{}
+				if len(appConfig.Env.Managed) > 0 {
+					serverEnv, err = utils.ReadRemoteEnv(ctx, executor, appConfig.Name)
+					if orchestrator.Failed(err, "") {
+						return
+					}
+				}
+				previewEnv, err = utils.PreviewEnv(appConfig.Env, serverEnv)
+				if orchestrator.Failed(err, "Something went wrong handling your env files") {
+					return
 				}
+				historyEntry.EnvHash = utils.EnvHash(previewEnv)
 			}
+			dockerEnvProperty := utils.ComposeEnvironment(utils.EnvKeys(previewEnv), appConfig.Env.PreviewVars())
 
 			imageName := fmt.Sprintf("%s:%s", appConfig.Name, deployHash)
-			serviceName := fmt.Sprintf("%s-%s", appConfig.Name, deployHash)
 			previewURL := fmt.Sprintf("%s.%s", deployHash, appConfig.Url)
-			newService := utils.DockerService{
-				Image: imageName,
-				Labels: []string{
-					"traefik.enable=true",
-					fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", serviceName, previewURL),
-					fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%s", serviceName, fmt.Sprint(appConfig.Port)),
-					fmt.Sprintf("traefik.http.routers.%s.tls=true", serviceName),
-					fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=default", serviceName),
-					"traefik.docker.network=sidekick",
-				},
-				Environment: dockerEnvProperty,
-				Networks: []string{
-					"sidekick",
-				},
-			}
-			newDockerCompose := utils.DockerComposeFile{
-				Services: map[string]utils.DockerService{
-					serviceName: newService,
-				},
-				Networks: map[string]utils.DockerNetwork{
-					"sidekick": {
-						External: true,
-					},
-				},
+			newDockerCompose, err := utils.PreviewComposeFile(appConfig, deployHash, dockerEnvProperty, previewAuth)
+			if orchestrator.Failed(err, "") {
+				return
 			}
 			dockerComposeFile, err := yaml.Marshal(&newDockerCompose)
-			if err != nil {
-				fmt.Printf("Error marshalling YAML: %v\n", err)
+			if orchestrator.Failed(err, "Something went wrong generating the docker compose file") {
 				return
 			}
-			err = os.WriteFile("docker-compose.yaml", dockerComposeFile, 0644)
-			if err != nil {
-				fmt.Printf("Error writing file: %v\n", err)
+			if err := os.WriteFile("docker-compose.yaml", dockerComposeFile, 0644); orchestrator.Failed(err, "Something went wrong writing the docker compose file") {
 				return
 			}
 
+			imgFileName := fmt.Sprintf("%s-%s.tar", appConfig.Name, deployHash)
+			previewFolder := fmt.Sprintf("./%s/preview/%s", appConfig.Name, deployHash)
+			orchestrator.OnCancel(func(ctx context.Context) string {
+				os.Remove("docker-compose.yaml")
+				os.Remove("encrypted.env")
+				os.Remove(imgFileName)
+				return utils.RollbackPreview(ctx, executor, appConfig.Name, deployHash, imgFileName)
+			})
+
 			cwd, _ := os.Getwd()
 			dockerImage := fmt.Sprintf("%s:%s", appConfig.Name, deployHash)
-			dockerBuildCmd := exec.Command("docker", "build", "--tag", dockerImage, "--progress=plain", "--platform=linux/amd64", cwd)
-			dockerBuildCmdErrPipe, _ := dockerBuildCmd.StderrPipe()
-			go render.SendLogsToTUI(dockerBuildCmdErrPipe, p)
-
-			if dockerBuildErr := dockerBuildCmd.Run(); dockerBuildErr != nil {
-				p.Send(render.ErrorMsg{})
+			dockerBuildArgs := []string{"build", "--tag", dockerImage, "--progress=plain", fmt.Sprintf("--platform=%s", viper.GetString("platformID")), cwd}
+			dockerBuildErr := executor.Local("docker "+strings.Join(dockerBuildArgs, " "), func() error {
+				dockerBuildCmd := exec.CommandContext(ctx, "docker", dockerBuildArgs...)
+				dockerBuildCmdErrPipe, _ := dockerBuildCmd.StderrPipe()
+				go render.SendLogsToTUI(dockerBuildCmdErrPipe, p)
+				return dockerBuildCmd.Run()
+			})
+			if orchestrator.Failed(dockerBuildErr, "Something went wrong building your docker image") {
+				return
 			}
 
 			time.Sleep(time.Millisecond * 100)
 
 			p.Send(render.NextStageMsg{})
 
-			imgFileName := fmt.Sprintf("%s-%s.tar", appConfig.Name, deployHash)
-			imgSaveCmd := exec.Command("docker", "save", "-o", imgFileName, dockerImage)
-			imgSaveCmdErrPipe, _ := imgSaveCmd.StderrPipe()
-			go render.SendLogsToTUI(imgSaveCmdErrPipe, p)
-
-			if imgSaveCmdErr := imgSaveCmd.Run(); imgSaveCmdErr != nil {
-				p.Send(render.ErrorMsg{})
+			imgSaveCmdErr := executor.Local(fmt.Sprintf("docker save -o %s %s", imgFileName, dockerImage), func() error {
+				imgSaveCmd := exec.CommandContext(ctx, "docker", "save", "-o", imgFileName, dockerImage)
+				imgSaveCmdErrPipe, _ := imgSaveCmd.StderrPipe()
+				go render.SendLogsToTUI(imgSaveCmdErrPipe, p)
+				return imgSaveCmd.Run()
+			})
+			if orchestrator.Failed(imgSaveCmdErr, "Something went wrong saving docker image to a file") {
+				return
 			}
 
 			time.Sleep(time.Millisecond * 100)
 
 			p.Send(render.NextStageMsg{})
 
-			_, _, sessionErr0 := utils.RunCommand(sshClient, fmt.Sprintf(`mkdir -p %s/preview/%s`, appConfig.Name, deployHash))
-			if sessionErr0 != nil {
-				p.Send(render.ErrorMsg{ErrorStr: sessionErr0.Error()})
+			_, sessionErr0 := executor.Run(ctx, fmt.Sprintf(`mkdir -p %s/preview/%s`, appConfig.Name, deployHash))
+			if orchestrator.Failed(sessionErr0, "") {
+				return
 			}
 
-			remoteDist := fmt.Sprintf("%s@%s:./%s", "sidekick", viper.GetString("serverAddress"), appConfig.Name)
-			imgMoveCmd := exec.Command("scp", "-C", imgFileName, remoteDist)
-			imgMoveCmdErrorPipe, _ := imgMoveCmd.StderrPipe()
-			go render.SendLogsToTUI(imgMoveCmdErrorPipe, p)
-
-			if imgMovCmdErr := imgMoveCmd.Run(); imgMovCmdErr != nil {
-				p.Send(render.ErrorMsg{})
+			if imgMovCmdErr := executor.Upload(ctx, imgFileName, fmt.Sprintf("./%s", appConfig.Name), p); orchestrator.Failed(imgMovCmdErr, "Something went wrong moving the image to your VPS") {
+				return
 			}
 
 			time.Sleep(time.Millisecond * 200)
 
-			dockerLoadOutChan, _, sessionErr := utils.RunCommand(sshClient, fmt.Sprintf("cd %s && docker load -i %s && rm %s", appConfig.Name, imgFileName, imgFileName))
-			go func() {
-				p.Send(render.LogMsg{LogLine: <-dockerLoadOutChan + "\n"})
-				time.Sleep(time.Millisecond * 50)
-			}()
-			if sessionErr != nil {
-				time.Sleep(time.Millisecond * 100)
-				p.Send(render.ErrorMsg{ErrorStr: sessionErr.Error()})
+			dockerLoadOut, sessionErr := executor.Run(ctx, fmt.Sprintf("cd %s && docker load -i %s && rm %s", appConfig.Name, imgFileName, imgFileName))
+			if orchestrator.Failed(sessionErr, "") {
+				return
 			}
+			p.Send(render.LogMsg{LogLine: dockerLoadOut})
 
 			time.Sleep(time.Millisecond * 100)
 			p.Send(render.NextStageMsg{})
 
-			previewFolder := fmt.Sprintf("./%s/preview/%s", appConfig.Name, deployHash)
-			rsyncCmd := exec.Command("rsync", "docker-compose.yaml", fmt.Sprintf("%s@%s:%s", "sidekick", viper.GetString("serverAddress"), previewFolder))
-			rsyncCmErr := rsyncCmd.Run()
-			if rsyncCmErr != nil {
-				p.Send(render.ErrorMsg{ErrorStr: rsyncCmErr.Error()})
+			rsyncCmErr := executor.Upload(ctx, "docker-compose.yaml", previewFolder, p)
+			if orchestrator.Failed(rsyncCmErr, "") {
+				return
 			}
 
-			if appConfig.Env.File != "" {
-				encryptSync := exec.Command("rsync", "encrypted.env", fmt.Sprintf("%s@%s:%s", "sidekick", viper.GetString("serverAddress"), previewFolder))
-				encryptSyncErrr := encryptSync.Run()
-				if encryptSyncErrr != nil {
-					p.Send(render.ErrorMsg{ErrorStr: encryptSyncErrr.Error()})
+			if appConfig.Env.HasPreviewEnv() {
+				encrypted, encryptErr := utils.EncryptEnv(previewEnv, utils.AgeRecipients())
+				if orchestrator.Failed(encryptErr, "") {
+					return
+				}
+				if orchestrator.Failed(executor.WriteFile(ctx, fmt.Sprintf("%s/encrypted.env", previewFolder), encrypted), "") {
+					return
 				}
 
-				runAppCmdOutChan, _, sessionErr1 := utils.RunCommand(sshClient, fmt.Sprintf(`cd %s && export SOPS_AGE_KEY=%s && sops exec-env encrypted.env 'docker compose -p sidekick up -d'`, previewFolder, viper.GetString("secretKey")))
-				go func() {
-					p.Send(render.LogMsg{LogLine: <-runAppCmdOutChan + "\n"})
-					time.Sleep(time.Millisecond * 50)
-				}()
-				if sessionErr1 != nil {
-					p.Send(render.ErrorMsg{ErrorStr: sessionErr1.Error()})
+				if orchestrator.Failed(utils.EnsureAgeKey(ctx, executor), "") {
+					return
 				}
+				runAppCmdOut, sessionErr1 := executor.Run(ctx, fmt.Sprintf("cd %s && %s", previewFolder, utils.SopsExecEnv("encrypted.env", "docker compose -p sidekick up -d")))
+				if orchestrator.Failed(sessionErr1, "") {
+					return
+				}
+				p.Send(render.LogMsg{LogLine: runAppCmdOut})
 			} else {
-				runAppCmdOutChan, _, sessionErr1 := utils.RunCommand(sshClient, fmt.Sprintf(`cd %s && docker compose -p sidekick up -d`, previewFolder))
-				go func() {
-					p.Send(render.LogMsg{LogLine: <-runAppCmdOutChan + "\n"})
-					time.Sleep(time.Millisecond * 50)
-				}()
-				if sessionErr1 != nil {
-					p.Send(render.ErrorMsg{ErrorStr: sessionErr1.Error()})
+				runAppCmdOut, sessionErr1 := executor.Run(ctx, fmt.Sprintf(`cd %s && docker compose -p sidekick up -d`, previewFolder))
+				if orchestrator.Failed(sessionErr1, "") {
+					return
 				}
+				p.Send(render.LogMsg{LogLine: runAppCmdOut})
 			}
 			previewEnvConfig := utils.SidekickPreview{
 				Url:       fmt.Sprintf("https://%s", previewURL),
 				Image:     imageName,
 				CreatedAt: time.Now().Format(time.UnixDate),
+				Auth:      previewAuth.Mode,
 			}
 			if len(appConfig.PreviewEnvs) == 0 {
 				appConfig.PreviewEnvs = map[string]utils.SidekickPreview{}
 			}
 			appConfig.PreviewEnvs[deployHash] = previewEnvConfig
 
-			ymlData, _ := yaml.Marshal(&appConfig)
-			os.WriteFile("./sidekick.yml", ymlData, 0644)
+			configErr := executor.Local(fmt.Sprintf("add preview %s to sidekick.yml", deployHash), func() error {
+				ymlData, err := yaml.Marshal(&appConfig)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile("./sidekick.yml", ymlData, 0644)
+			})
+			if orchestrator.Failed(configErr, "Failed to update sidekick.yml") {
+				return
+			}
 
 			os.Remove("docker-compose.yaml")
-			os.Remove("encrypted.env")
 			os.Remove(imgFileName)
 
-			p.Send(render.AllDoneMsg{Message: "🚀 Deployed successfully in " + time.Since(start).Round(time.Second).String() + ".\n" + "😎 View your app at https://" + previewURL})
+			doneMessage := "🚀 Deployed successfully in " + time.Since(start).Round(time.Second).String() + ".\n" + "😎 View your app at https://" + previewURL
+			if previewAuth.Mode != utils.PreviewAuthNone {
+				doneMessage += "\n🔒 Protected with " + previewAuth.String()
+			}
+			orchestrator.Done(plan.DoneMessage(doneMessage))
 
 		}()
 
-		if _, err := p.Run(); err != nil {
-			fmt.Println("Error running program:", err)
+		if err := p.Run(); err != nil {
+			if !errors.Is(err, render.ErrStageFailed) {
+				fmt.Println("Error running program:", err)
+			}
 			os.Exit(1)
 		}
+		plan.Print(os.Stdout)
 	},
 }
 
 func init() {
+	PreviewCmd.Flags().Bool("dry-run", false, "Show what preview would change on your VPS without changing anything")
 	PreviewCmd.AddCommand(previewList.ListCmd)
 	PreviewCmd.AddCommand(previewRemove.RemoveCmd)
 }
@@ -17,9 +17,20 @@ package cmd
 import (
 	"os"
 
+	"github.com/mightymoud/sidekick/cmd/certs"
+	"github.com/mightymoud/sidekick/cmd/db"
 	"github.com/mightymoud/sidekick/cmd/deploy"
+	"github.com/mightymoud/sidekick/cmd/domains"
+	"github.com/mightymoud/sidekick/cmd/env"
+	"github.com/mightymoud/sidekick/cmd/history"
+	"github.com/mightymoud/sidekick/cmd/keys"
 	"github.com/mightymoud/sidekick/cmd/launch"
+	"github.com/mightymoud/sidekick/cmd/lock"
 	"github.com/mightymoud/sidekick/cmd/preview"
+	"github.com/mightymoud/sidekick/cmd/status"
+	"github.com/mightymoud/sidekick/cmd/traefik"
+	"github.com/mightymoud/sidekick/cmd/unlock"
+	"github.com/mightymoud/sidekick/render"
 	"github.com/spf13/cobra"
 )
 
@@ -30,6 +41,10 @@ var rootCmd = &cobra.Command{
 	Version: version,
 	Short:   "CLI to self-host all your apps on a single VPS without vendor locking",
 	Long:    `With sidekick you can deploy any number of applications to a single VPS, connect multiple domains and much more.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		outputMode, _ := cmd.Flags().GetString("output")
+		return render.SetOutputMode(outputMode)
+	},
 }
 
 func Execute() {
@@ -41,7 +56,18 @@ func Execute() {
 
 func init() {
 	rootCmd.SetVersionTemplate(`{{println .Version}}`)
+	rootCmd.PersistentFlags().String("output", render.OutputAuto, "Output format: auto, tui, plain or json. auto uses plain when stdout is not a terminal")
 	rootCmd.AddCommand(preview.PreviewCmd)
 	rootCmd.AddCommand(deploy.DeployCmd)
 	rootCmd.AddCommand(launch.LaunchCmd)
+	rootCmd.AddCommand(lock.LockCmd)
+	rootCmd.AddCommand(history.HistoryCmd)
+	rootCmd.AddCommand(env.EnvCmd)
+	rootCmd.AddCommand(keys.KeysCmd)
+	rootCmd.AddCommand(traefik.TraefikCmd)
+	rootCmd.AddCommand(db.DbCmd)
+	rootCmd.AddCommand(domains.DomainsCmd)
+	rootCmd.AddCommand(unlock.UnlockCmd)
+	rootCmd.AddCommand(certs.CertsCmd)
+	rootCmd.AddCommand(status.StatusCmd)
 }
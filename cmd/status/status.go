@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package status
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// certificates expiring sooner get a warning, Let's Encrypt renews them 30 days ahead
+const expiryWarning = 14 * 24 * time.Hour
+
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show how your application is running on your VPS",
+	Long:  `This command shows the state of the container of your application and the certificates served for its domains, with when they expire.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Status"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		state, _ := executor.Query(ctx, fmt.Sprintf("docker ps -a -f label=com.docker.compose.service=%s --format '{{.Status}}'", appConfig.Name))
+		state = strings.TrimSpace(state)
+		if state == "" {
+			state = "not running"
+		}
+
+		certsTable := table.New().
+			Border(lipgloss.RoundedBorder()).
+			BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color("99"))).
+			StyleFunc(func(row, col int) lipgloss.Style {
+				switch {
+				case row == 0:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("60")).Align(lipgloss.Center)
+				default:
+					return lipgloss.NewStyle().Foreground(lipgloss.Color("78")).PaddingLeft(1).PaddingRight(1)
+				}
+			}).
+			Headers("Domain", "Certificate", "Issuer", "Expires")
+
+		warnings := []string{}
+		seen := map[string]bool{}
+		for _, domain := range appConfig.AppDomains() {
+			if seen[domain.Host] {
+				continue
+			}
+			seen[domain.Host] = true
+			source := "Let's Encrypt"
+			if domain.CustomCert {
+				source = "custom"
+			}
+			certificate, err := utils.ServedCertificate(ctx, executor, domain.Host)
+			if err != nil {
+				certsTable.Row(domain.Host, source, "-", "none served yet")
+				warnings = append(warnings, err.Error())
+				continue
+			}
+			left := time.Until(certificate.NotAfter)
+			certsTable.Row(domain.Host, source, certificate.Issuer.CommonName, fmt.Sprintf("%s (%d days)", certificate.NotAfter.Format(time.DateOnly), int(left.Hours()/24)))
+			if left < expiryWarning {
+				warnings = append(warnings, fmt.Sprintf("the certificate of %s expires on %s", domain.Host, certificate.NotAfter.Format(time.DateOnly)))
+			}
+		}
+
+		header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1).MarginLeft(1).Render(fmt.Sprintf("%s is %s", appConfig.Name, state))
+		fmt.Println(header)
+		fmt.Println(certsTable)
+		for _, warning := range warnings {
+			render.GetLogger(log.Options{Prefix: "Certs"}).Warn(warning)
+		}
+	},
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package traefikDashboard
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var DashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Open the Traefik dashboard through an SSH tunnel",
+	Long: `This command forwards a local port over SSH to the Traefik dashboard, which only listens on the loopback of your VPS.
+Enable the dashboard first with sidekick traefik reconfigure --dashboard.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		port, _ := cmd.Flags().GetInt("port")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		if !utils.LoadTraefikConfig().Dashboard {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("The dashboard is disabled - enable it with sidekick traefik reconfigure --dashboard")
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("Unable to login to your VPS")
+		}
+		defer sshClient.Close()
+
+		listener, err := utils.ForwardPort(sshClient, fmt.Sprintf("127.0.0.1:%d", port), fmt.Sprintf("127.0.0.1:%d", utils.TraefikDashboardPort))
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal(err)
+		}
+		defer listener.Close()
+
+		render.GetLogger(log.Options{Prefix: "Traefik"}).Infof("Dashboard at http://%s/dashboard/ - press Ctrl+C to stop", listener.Addr())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+	},
+}
+
+func init() {
+	DashboardCmd.Flags().IntP("port", "p", 8080, "Local port to open the dashboard on")
+}
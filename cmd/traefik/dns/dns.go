@@ -0,0 +1,133 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package traefikDns
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	envKeyPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	providerPattern = regexp.MustCompile(`^[a-z0-9.]+$`)
+)
+
+var DnsCmd = &cobra.Command{
+	Use:   "dns PROVIDER [KEY=VALUE...]",
+	Short: "Get certificates with the DNS challenge, needed for wildcards",
+	Long: `This command adds a DNS challenge certificate resolver to Traefik on your VPS, for any DNS provider Traefik supports.
+The credentials of the provider are encrypted into traefik/encrypted.env on your VPS and only reach Traefik at start up.
+Set wildcard: true in the sidekick.yml of an application so it gets a *.<url> certificate its previews share.`,
+	Example: "  sidekick traefik dns cloudflare CF_DNS_API_TOKEN=abc123\n  sidekick traefik dns --disable",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if disable, _ := cmd.Flags().GetBool("disable"); disable {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		disable, _ := cmd.Flags().GetBool("disable")
+		resolvers, _ := cmd.Flags().GetStringSlice("resolvers")
+		caServer, _ := cmd.Flags().GetString("ca-server")
+
+		dns := utils.TraefikDNSChallenge{}
+		credentials := map[string]string{}
+		if !disable {
+			if !providerPattern.MatchString(args[0]) {
+				render.GetLogger(log.Options{Prefix: "Traefik"}).Fatalf("Invalid provider %q - use the name from the Traefik docs, like cloudflare", args[0])
+			}
+			dns = utils.TraefikDNSChallenge{Provider: args[0], Resolvers: resolvers, CAServer: caServer}
+			for _, arg := range args[1:] {
+				key, value, found := strings.Cut(arg, "=")
+				if !found || !envKeyPattern.MatchString(key) {
+					render.GetLogger(log.Options{Prefix: "Traefik"}).Fatalf("Invalid argument %q - use KEY=VALUE", arg)
+				}
+				credentials[key] = value
+				render.RegisterSecret(value)
+			}
+		}
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		traefikConfig := utils.LoadTraefikConfig()
+		if traefikConfig.Email == "" {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("No email for certificates in your sidekick config - run sidekick init")
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		spinner.New().
+			Title("Restarting Traefik...").
+			Action(func() {
+				err = utils.WithLock(ctx, executor, utils.TraefikDir, "sidekick traefik dns", func() error {
+					env := map[string]string{}
+					// the same provider keeps the credentials that weren't given again
+					if !disable && dns.Provider == traefikConfig.DNSChallenge.Provider {
+						env, err = utils.ReadRemoteEnv(ctx, executor, utils.TraefikDir)
+						if err != nil {
+							return err
+						}
+					}
+					for key, value := range credentials {
+						env[key] = value
+					}
+					dns.Keys = utils.EnvKeys(env)
+					if len(env) > 0 {
+						if err := utils.WriteRemoteEnv(ctx, executor, utils.TraefikDir, env); err != nil {
+							return err
+						}
+					} else if _, err := executor.Run(ctx, fmt.Sprintf("rm -f %s/encrypted.env", utils.TraefikDir)); err != nil {
+						return err
+					}
+					traefikConfig.DNSChallenge = dns
+					if err := utils.RestartTraefik(ctx, executor, traefikConfig); err != nil {
+						return err
+					}
+					return utils.SaveTraefikConfig(traefikConfig)
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal(err)
+		}
+
+		if disable {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Info("Removed the DNS challenge - apps with wildcard: true won't get new certificates")
+			return
+		}
+		render.GetLogger(log.Options{Prefix: "Traefik"}).Infof("Traefik can now get certificates from %s DNS records - set wildcard: true in sidekick.yml and deploy", dns.Provider)
+	},
+}
+
+func init() {
+	DnsCmd.Flags().StringSlice("resolvers", nil, "DNS servers to check the challenge records on, like 1.1.1.1:53")
+	DnsCmd.Flags().String("ca-server", "", "ACME directory to use instead of Let's Encrypt, like a Pebble server for testing")
+	DnsCmd.Flags().Bool("disable", false, "Remove the DNS challenge resolver")
+}
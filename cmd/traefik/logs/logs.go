@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package traefikLogs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var LogsCmd = &cobra.Command{
+	Use:     "logs",
+	Short:   "Show the logs of Traefik on your VPS",
+	Long:    `This command prints the logs of Traefik, with the access logs if you enabled them with sidekick traefik reconfigure --access-logs.`,
+	Example: "  sidekick traefik logs --follow",
+	Run: func(cmd *cobra.Command, args []string) {
+		follow, _ := cmd.Flags().GetBool("follow")
+		tail, _ := cmd.Flags().GetInt("tail")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("Unable to login to your VPS")
+		}
+		defer sshClient.Close()
+
+		session, err := sshClient.NewSession()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal(err)
+		}
+		defer session.Close()
+		session.Stdout = os.Stdout
+		session.Stderr = os.Stderr
+
+		logsCmd := fmt.Sprintf("docker logs --tail %d", tail)
+		if follow {
+			logsCmd += " --follow"
+		}
+		logsCmd += fmt.Sprintf(" $(docker ps -q -f label=com.docker.compose.service=%s | head -n 1)", utils.TraefikService)
+		if err := session.Run(logsCmd); err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal(err)
+		}
+	},
+}
+
+func init() {
+	LogsCmd.Flags().BoolP("follow", "f", false, "Keep printing new logs")
+	LogsCmd.Flags().Int("tail", 100, "Number of lines to show from the end of the logs")
+}
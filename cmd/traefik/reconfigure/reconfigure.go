@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package traefikReconfigure
+
+import (
+	"context"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ReconfigureCmd = &cobra.Command{
+	Use:   "reconfigure",
+	Short: "Change how Traefik runs on your VPS",
+	Long: `This command regenerates the compose file of Traefik from your sidekick config and recreates Traefik.
+Only the flags you pass change, the rest of the config stays as it is.`,
+	Example: "  sidekick traefik reconfigure --email ops@example.com --access-logs\n  sidekick traefik reconfigure --dashboard=false",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		traefikConfig := utils.LoadTraefikConfig()
+		if cmd.Flags().Changed("email") {
+			traefikConfig.Email, _ = cmd.Flags().GetString("email")
+		}
+		if cmd.Flags().Changed("access-logs") {
+			traefikConfig.AccessLogs, _ = cmd.Flags().GetBool("access-logs")
+		}
+		if cmd.Flags().Changed("dashboard") {
+			traefikConfig.Dashboard, _ = cmd.Flags().GetBool("dashboard")
+		}
+		if traefikConfig.Email == "" {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("An email is needed for certificates - use --email")
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		spinner.New().
+			Title("Reconfiguring Traefik...").
+			Action(func() {
+				err = utils.WithLock(ctx, executor, utils.TraefikDir, "sidekick traefik reconfigure", func() error {
+					if err := utils.RestartTraefik(ctx, executor, traefikConfig); err != nil {
+						return err
+					}
+					return utils.SaveTraefikConfig(traefikConfig)
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Traefik"}).Info("Traefik restarted with the new config")
+		if traefikConfig.Dashboard {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Info("Open the dashboard with sidekick traefik dashboard")
+		}
+	},
+}
+
+func init() {
+	ReconfigureCmd.Flags().String("email", "", "Email for Let's Encrypt certificates")
+	ReconfigureCmd.Flags().Bool("access-logs", false, "Log every request Traefik handles")
+	ReconfigureCmd.Flags().Bool("dashboard", false, "Serve the Traefik dashboard on the loopback of your VPS")
+}
@@ -0,0 +1,38 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package traefik
+
+import (
+	traefikDashboard "github.com/mightymoud/sidekick/cmd/traefik/dashboard"
+	traefikDns "github.com/mightymoud/sidekick/cmd/traefik/dns"
+	traefikLogs "github.com/mightymoud/sidekick/cmd/traefik/logs"
+	traefikReconfigure "github.com/mightymoud/sidekick/cmd/traefik/reconfigure"
+	traefikUpgrade "github.com/mightymoud/sidekick/cmd/traefik/upgrade"
+	"github.com/spf13/cobra"
+)
+
+var TraefikCmd = &cobra.Command{
+	Use:   "traefik",
+	Short: "Manage Traefik on your VPS",
+	Long:  `These commands change how Traefik, the reverse proxy in front of all your applications, is set up on your VPS.`,
+}
+
+func init() {
+	TraefikCmd.AddCommand(traefikDns.DnsCmd)
+	TraefikCmd.AddCommand(traefikUpgrade.UpgradeCmd)
+	TraefikCmd.AddCommand(traefikReconfigure.ReconfigureCmd)
+	TraefikCmd.AddCommand(traefikDashboard.DashboardCmd)
+	TraefikCmd.AddCommand(traefikLogs.LogsCmd)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package traefikUpgrade
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var versionPattern = regexp.MustCompile(`^v\d+\.\d+(\.\d+)?$`)
+
+var UpgradeCmd = &cobra.Command{
+	Use:   "upgrade [VERSION]",
+	Short: "Upgrade Traefik on your VPS",
+	Long: `This command pulls a new Traefik image and recreates Traefik with it. Without a version it upgrades to the one this release of Sidekick is tested with.
+Traefik is down for the few seconds it takes to recreate it.`,
+	Example: "  sidekick traefik upgrade v3.6.2",
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		version := utils.DefaultTraefikVersion
+		if len(args) == 1 {
+			version = args[0]
+		}
+		if !versionPattern.MatchString(version) {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatalf("Invalid version %q - use a tag like %s", version, utils.DefaultTraefikVersion)
+		}
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		traefikConfig := utils.LoadTraefikConfig()
+		if traefikConfig.Version == version {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Infof("Traefik is already on %s", version)
+			return
+		}
+		previous := traefikConfig.Version
+		traefikConfig.Version = version
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal("Unable to login to your VPS")
+		}
+		executor := utils.NewSSHExecutor(sshClient)
+		ctx := context.Background()
+
+		spinner.New().
+			Title(fmt.Sprintf("Upgrading Traefik to %s...", version)).
+			Action(func() {
+				err = utils.WithLock(ctx, executor, utils.TraefikDir, "sidekick traefik upgrade", func() error {
+					// pulling first keeps the old Traefik running if the tag doesn't exist
+					if _, err := executor.Run(ctx, fmt.Sprintf("docker pull traefik:%s", version)); err != nil {
+						return fmt.Errorf("failed to pull traefik:%s: %w", version, err)
+					}
+					if err := utils.RestartTraefik(ctx, executor, traefikConfig); err != nil {
+						return err
+					}
+					return utils.SaveTraefikConfig(traefikConfig)
+				})
+			}).
+			Run()
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Traefik"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Traefik"}).Infof("Upgraded Traefik from %s to %s", previous, version)
+	},
+}
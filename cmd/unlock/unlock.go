@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package unlock
+
+import (
+	"context"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/mightymoud/sidekick/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var UnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove a stale deploy lock of your application",
+	Long: `This command removes the deploy lock of your application.
+Without --force it only removes a lock left behind by a run of yours on this machine that is no longer running.
+Use --force to remove a stale lock taken by someone else - make sure their run is really gone first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+
+		if configErr := utils.ViperInit(); configErr != nil {
+			render.GetLogger(log.Options{Prefix: "Sidekick Config"}).Fatal("Not found - Run Sidekick init first")
+		}
+		appConfig, appConfigErr := utils.LoadAppConfig()
+		if appConfigErr != nil {
+			render.GetLogger(log.Options{Prefix: "Project Config"}).Fatal(appConfigErr)
+		}
+
+		sshClient, err := utils.Login(viper.GetString("serverAddress"), "sidekick")
+		if err != nil {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatal("Unable to login to your VPS")
+		}
+
+		ctx := context.Background()
+		executor := utils.NewSSHExecutor(sshClient)
+		lock, err := utils.ReadLock(ctx, executor, appConfig.Name)
+		if err != nil && !force {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatal(err)
+		}
+		if lock == nil && err == nil {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Infof("Free - nothing to unlock for %s", appConfig.Name)
+			return
+		}
+		if lock != nil && !lock.IsOwnedByMe() && !force {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatalf("Held by %s on %s since %s - use --force if that run is gone", lock.Owner, lock.Host, lock.AcquiredAt)
+		}
+		if lock != nil && !lock.IsStale() && !force {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatalf("Held by %s (pid %d) on this machine, which is still running - wait for it to finish or use --force", lock.Command, lock.PID)
+		}
+
+		if err := utils.ForceUnlock(ctx, executor, appConfig.Name); err != nil {
+			render.GetLogger(log.Options{Prefix: "Lock"}).Fatal(err)
+		}
+		render.GetLogger(log.Options{Prefix: "Lock"}).Infof("Removed the deploy lock of %s", appConfig.Name)
+	},
+}
+
+func init() {
+	UnlockCmd.Flags().BoolP("force", "f", false, "Remove the lock even if someone else holds it")
+}
@@ -0,0 +1,264 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+const (
+	OutputAuto  = "auto"
+	OutputTUI   = "tui"
+	OutputPlain = "plain"
+	OutputJSON  = "json"
+)
+
+// ErrStageFailed is returned by Output.Run when a stage reported an error
+var ErrStageFailed = errors.New("a stage failed")
+
+// ErrCancelled is returned by Output.Run when the user cancelled the command
+var ErrCancelled = fmt.Errorf("%w: cancelled by user", ErrStageFailed)
+
+var outputMode = OutputAuto
+
+// Output is where commands send their stage messages (LogMsg, NextStageMsg...)
+// It's either the bubbletea TUI or a line oriented stream meant for CI logs
+type Output interface {
+	Send(msg tea.Msg)
+	Run() error
+}
+
+func SetOutputMode(mode string) error {
+	switch mode {
+	case OutputAuto, OutputTUI, OutputPlain, OutputJSON:
+		outputMode = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown output mode %q - use one of auto, tui, plain or json", mode)
+	}
+}
+
+// GetOutputMode resolves auto to tui when stdout is a terminal and to plain otherwise
+func GetOutputMode() string {
+	if outputMode != OutputAuto {
+		return outputMode
+	}
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		return OutputTUI
+	}
+	return OutputPlain
+}
+
+func NewOutput(model TuiModel) Output {
+	switch GetOutputMode() {
+	case OutputPlain:
+		return newLineOutput(model, os.Stdout, writePlainEvent)
+	case OutputJSON:
+		return newLineOutput(model, os.Stdout, writeJSONEvent)
+	default:
+		return &tuiOutput{program: tea.NewProgram(model)}
+	}
+}
+
+type tuiOutput struct {
+	program *tea.Program
+}
+
+func (o *tuiOutput) Send(msg tea.Msg) {
+	o.program.Send(msg)
+}
+
+func (o *tuiOutput) Run() error {
+	model, err := o.program.Run()
+	if err != nil {
+		return err
+	}
+	if m, ok := model.(TuiModel); ok {
+		if m.Cancelled || (m.Quitting && !m.AllDone) {
+			return ErrCancelled
+		}
+		if m.Stages[m.ActiveIndex].HasError {
+			return ErrStageFailed
+		}
+	}
+	return nil
+}
+
+// Event is a single line of the plain and json outputs
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"event"`
+	Stage   int       `json:"stage,omitempty"`
+	Stages  int       `json:"stages,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+const (
+	EventStart         = "start"
+	EventStageStarted  = "stage_started"
+	EventStageFinished = "stage_finished"
+	EventLog           = "log"
+	EventError         = "error"
+	EventDone          = "done"
+	EventCancelled     = "cancelled"
+)
+
+// statuses of the stage an event is about
+const (
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+type eventWriter func(w io.Writer, event Event)
+
+type lineOutput struct {
+	model    TuiModel
+	w        io.Writer
+	write    eventWriter
+	msgs     chan tea.Msg
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newLineOutput(model TuiModel, w io.Writer, write eventWriter) *lineOutput {
+	return &lineOutput{
+		model: model,
+		w:     w,
+		write: write,
+		msgs:  make(chan tea.Msg, 100),
+		done:  make(chan struct{}),
+	}
+}
+
+func (o *lineOutput) Send(msg tea.Msg) {
+	select {
+	case o.msgs <- msg:
+	case <-o.done:
+	}
+}
+
+func (o *lineOutput) Run() error {
+	defer o.doneOnce.Do(func() { close(o.done) })
+
+	o.emit(Event{Type: EventStart, Stages: len(o.model.Stages), Message: o.model.BannerMsg})
+	o.emitStageStarted()
+
+	for msg := range o.msgs {
+		m := &o.model
+		switch msg := msg.(type) {
+		case LogMsg:
+			m.Stages[m.ActiveIndex].Logs = append(m.Stages[m.ActiveIndex].Logs, msg.LogLine)
+			for _, line := range strings.Split(strings.TrimRight(msg.LogLine, "\n"), "\n") {
+				if strings.TrimSpace(line) != "" {
+					o.emit(Event{Type: EventLog, Stage: m.ActiveIndex + 1, Message: line})
+				}
+			}
+
+		case NextStageMsg:
+			o.emitStageFinished(m.ActiveIndex)
+			if m.ActiveIndex+1 < len(m.Stages) {
+				m.ActiveIndex++
+				o.emitStageStarted()
+			}
+
+		case ErrorMsg:
+			stage := m.Stages[m.ActiveIndex]
+			stage.HasError = true
+			if msg.ErrorStr != "" {
+				stage.Logs = append(stage.Logs, msg.ErrorStr)
+			}
+			m.Stages[m.ActiveIndex] = stage
+			WriteStageLogs(stage, m.ActiveIndex)
+
+			o.emit(Event{Type: EventError, Stage: m.ActiveIndex + 1, Title: stage.Title, Status: StatusFailed, Error: msg.ErrorStr})
+			return ErrStageFailed
+
+		case CancelledMsg:
+			m.Cancelled = true
+			o.emit(Event{Type: EventCancelled, Stage: m.ActiveIndex + 1, Title: m.Stages[m.ActiveIndex].Title, Status: StatusCancelled, Message: msg.Report, Error: ErrCancelled.Error()})
+			return ErrCancelled
+
+		case AllDoneMsg:
+			for index := m.ActiveIndex; index < len(m.Stages); index++ {
+				o.emitStageFinished(index)
+			}
+			m.AllDone = true
+			o.emit(Event{Type: EventDone, Status: StatusDone, Message: msg.Message})
+			return nil
+		}
+	}
+	return nil
+}
+
+func (o *lineOutput) emitStageStarted() {
+	stage := o.model.Stages[o.model.ActiveIndex]
+	o.emit(Event{Type: EventStageStarted, Stage: o.model.ActiveIndex + 1, Stages: len(o.model.Stages), Title: stage.Title, Status: StatusRunning})
+}
+
+func (o *lineOutput) emitStageFinished(index int) {
+	stage := o.model.Stages[index]
+	o.emit(Event{Type: EventStageFinished, Stage: index + 1, Stages: len(o.model.Stages), Title: stage.Title, Status: StatusDone, Message: stage.Success})
+}
+
+func (o *lineOutput) emit(event Event) {
+	event.Time = time.Now()
+	event.Message = Redact(event.Message)
+	event.Error = Redact(event.Error)
+	o.write(o.w, event)
+}
+
+func writePlainEvent(w io.Writer, event Event) {
+	switch event.Type {
+	case EventStart:
+		fmt.Fprintf(w, "==> %s\n", event.Message)
+	case EventStageStarted:
+		fmt.Fprintf(w, "[%d/%d] %s...\n", event.Stage, event.Stages, event.Title)
+	case EventStageFinished:
+		fmt.Fprintf(w, "[%d/%d] ✔ %s\n", event.Stage, event.Stages, event.Message)
+	case EventLog:
+		fmt.Fprintf(w, "      %s\n", event.Message)
+	case EventError:
+		fmt.Fprintf(w, "✖ %s failed: %s\n", event.Title, event.Error)
+		fmt.Fprintln(w, "Check sidekick.logs.txt for more details")
+	case EventDone:
+		fmt.Fprintf(w, "==> %s\n", event.Message)
+	case EventCancelled:
+		fmt.Fprintf(w, "✖ Cancelled during %s\n", event.Title)
+		fmt.Fprintln(w, event.Message)
+	}
+}
+
+func writeJSONEvent(w io.Writer, event Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(w, string(line))
+}
@@ -0,0 +1,121 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+)
+
+func runLineOutput(t *testing.T, write eventWriter, msgs []tea.Msg) (string, error) {
+	// failed stages write sidekick.logs.txt to the working directory
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(dir)
+
+	var buf bytes.Buffer
+	output := newLineOutput(TuiModel{
+		BannerMsg: "Sidekick booting up! 🚀",
+		Stages: []Stage{
+			MakeStage("Building", "Built", true),
+			MakeStage("Deploying", "Deployed", true),
+		},
+	}, &buf, write)
+	go func() {
+		for _, msg := range msgs {
+			output.Send(msg)
+		}
+	}()
+	err := output.Run()
+	return buf.String(), err
+}
+
+var outputCases = []struct {
+	name    string
+	msgs    []tea.Msg
+	err     error
+	plain   []string
+	status  string
+	errorIs string
+}{
+	{
+		name:   "done",
+		msgs:   []tea.Msg{LogMsg{LogLine: "step 1\n\nstep 2\n"}, NextStageMsg{}, AllDoneMsg{Message: "All done"}},
+		plain:  []string{"==> Sidekick booting up! 🚀", "[1/2] Building...", "      step 1", "      step 2", "[1/2] ✔ Built", "[2/2] Deploying...", "[2/2] ✔ Deployed", "==> All done"},
+		status: StatusDone,
+	},
+	{
+		name:    "failed",
+		msgs:    []tea.Msg{NextStageMsg{}, ErrorMsg{ErrorStr: "upload failed"}},
+		err:     ErrStageFailed,
+		plain:   []string{"[2/2] Deploying...", "✖ Deploying failed: upload failed", "Check sidekick.logs.txt for more details"},
+		status:  StatusFailed,
+		errorIs: "upload failed",
+	},
+	{
+		name:    "cancelled",
+		msgs:    []tea.Msg{CancelledMsg{Report: "Removed the new container"}},
+		err:     ErrCancelled,
+		plain:   []string{"✖ Cancelled during Building", "Removed the new container"},
+		status:  StatusCancelled,
+		errorIs: ErrCancelled.Error(),
+	},
+}
+
+func TestPlainOutput(t *testing.T) {
+	for _, tc := range outputCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runLineOutput(t, writePlainEvent, tc.msgs)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			for _, line := range tc.plain {
+				assert.Contains(t, lines, line)
+			}
+		})
+	}
+	// a cancel is also a failed stage for callers checking ErrStageFailed
+	assert.ErrorIs(t, ErrCancelled, ErrStageFailed)
+}
+
+func TestJSONOutput(t *testing.T) {
+	for _, tc := range outputCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runLineOutput(t, writeJSONEvent, tc.msgs)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			}
+			events := []map[string]any{}
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				event := map[string]any{}
+				assert.NoError(t, json.Unmarshal([]byte(line), &event))
+				events = append(events, event)
+			}
+			assert.Equal(t, "start", events[0]["event"])
+			assert.Equal(t, float64(1), events[1]["stage"])
+			assert.Equal(t, StatusRunning, events[1]["status"])
+
+			last := events[len(events)-1]
+			assert.Equal(t, tc.status, last["status"])
+			if tc.errorIs != "" {
+				assert.Equal(t, tc.errorIs, last["error"])
+				assert.NotZero(t, last["stage"])
+			} else {
+				assert.NotContains(t, last, "error")
+			}
+		})
+	}
+}
+
+func TestOutputRedactsSecrets(t *testing.T) {
+	RegisterSecret("hunter22")
+	out, _ := runLineOutput(t, writeJSONEvent, []tea.Msg{ErrorMsg{ErrorStr: "login with hunter22 failed"}})
+	assert.NotContains(t, out, "hunter22")
+}
@@ -17,6 +17,7 @@ package render
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -47,7 +48,24 @@ func (m TuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 
 	case tea.KeyMsg:
+		if msg.Type != tea.KeyCtrlC {
+			return m, nil
+		}
+		// without a context to cancel, or on a second ctrl+c, just leave
+		if m.Cancel == nil || m.Cancelling {
+			m.Quitting = true
+			return m, tea.Quit
+		}
+		// let the running command roll back and report back with a CancelledMsg
+		m.Cancelling = true
+		m.Cancel()
+
+		return m, nil
+
+	case CancelledMsg:
 		m.Quitting = true
+		m.Cancelled = true
+		m.FinalMessage = Redact(msg.Report)
 
 		return m, tea.Quit
 
@@ -58,7 +76,7 @@ func (m TuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case LogMsg:
 		logStage := m.Stages[m.ActiveIndex]
-		logStage.Logs = append(logStage.Logs, msg.LogLine)
+		logStage.Logs = append(logStage.Logs, Redact(msg.LogLine))
 		m.Stages[m.ActiveIndex] = logStage
 
 		return m, nil
@@ -67,7 +85,7 @@ func (m TuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		logStage := m.Stages[m.ActiveIndex]
 		logStage.HasError = true
 		if msg.ErrorStr != "" {
-			logStage.Logs = append(logStage.Logs, msg.ErrorStr)
+			logStage.Logs = append(logStage.Logs, Redact(msg.ErrorStr))
 		}
 		m.Stages[m.ActiveIndex] = logStage
 
@@ -111,14 +129,18 @@ func (m TuiModel) View() string {
 			if index < m.ActiveIndex {
 				printSlice = append(printSlice, successStyle.Render("✔ "+stage.Success))
 			} else if index == m.ActiveIndex {
-				if !stage.HasError {
+				if m.Cancelled {
+					printSlice = append(printSlice, errorStyle.Render("✖ CANCELLED "+stage.Title))
+				} else if m.Cancelling {
+					printSlice = append(printSlice, stage.Spinner.View()+cancelStyle.Render("Cancelling - rolling back "+stage.Title))
+				} else if !stage.HasError {
 					printSlice = append(printSlice, stage.Spinner.View()+stage.Title)
 				} else {
 					u := tree.Root("⚠ " + stage.Title).Child(stage.Logs)
 					printSlice = append(printSlice, errorStyle.Render(u.String()))
 					printSlice = append(printSlice, allDoneStyle.Render("⚠️ Check sidekick.logs.txt for more details"))
 				}
-				if stage.HasLogs && !stage.HasError {
+				if stage.HasLogs && !stage.HasError && !m.Quitting {
 					var t string
 					if !stage.HasError {
 						l := len(stage.Logs)
@@ -134,7 +156,7 @@ func (m TuiModel) View() string {
 				}
 			} else if index > m.ActiveIndex {
 				var text string
-				if m.Quitting {
+				if m.Quitting || m.Cancelling {
 					text = cancelStyle.Render("CANCELLED " + stage.Title)
 				} else {
 					text = pendingStyle.Render("󰚭 " + stage.Title)
@@ -150,6 +172,10 @@ func (m TuiModel) View() string {
 		printSlice = append(printSlice, allDoneStyle.Render(m.FinalMessage))
 	}
 
+	if m.Cancelled {
+		printSlice = append(printSlice, allDoneStyle.Render(m.FinalMessage))
+	}
+
 	s += lipgloss.JoinVertical(lipgloss.Top, printSlice...)
 
 	s += "\n"
@@ -199,11 +225,17 @@ func MakeStage(title string, success string, hasLogs bool) Stage {
 	}
 }
 
-func SendDockerBuildLogsToTUI(resBody io.ReadCloser, p *tea.Program) {
+func SendDockerBuildLogsToTUI(resBody io.ReadCloser, p Output) {
 	dec := json.NewDecoder(resBody)
 	for {
 		var msg buildMsg
-		if err := dec.Decode(&msg); err == io.EOF {
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		// a cancelled build closes the body, stop instead of decoding forever
+		var typeErr *json.UnmarshalTypeError
+		if err != nil && !errors.As(err, &typeErr) {
 			break
 		}
 		// skip errors here cus shape is wront anyway -> to fix this later
@@ -232,7 +264,7 @@ func SendDockerBuildLogsToTUI(resBody io.ReadCloser, p *tea.Program) {
 	}
 }
 
-func SendLogsToTUI(source io.ReadCloser, p *tea.Program) {
+func SendLogsToTUI(source io.ReadCloser, p Output) {
 	scanner := bufio.NewScanner(source)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -265,6 +297,7 @@ func WriteStageLogs(stage Stage, stageIndex int) error {
 	}
 
 	for _, log := range stage.Logs {
+		log = Redact(log)
 		if _, err := file.WriteString(log); err != nil {
 			return err
 		}
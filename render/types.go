@@ -15,6 +15,8 @@ limitations under the License.
 package render
 
 import (
+	"context"
+
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -32,6 +34,12 @@ type ErrorMsg struct {
 }
 type NextStageMsg struct{}
 
+// CancelledMsg is sent once a cancelled command finished rolling back
+// Report describes the state the server was left in
+type CancelledMsg struct {
+	Report string
+}
+
 type Stage struct {
 	Title    string
 	Success  string
@@ -46,6 +54,9 @@ type TuiModel struct {
 	ActiveIndex    int
 	Stages         []Stage
 	Quitting       bool
+	Cancelling     bool
+	Cancelled      bool
+	Cancel         context.CancelFunc
 	ViewportWidth  int
 	ViewportHeight int
 	AllDone        bool
@@ -0,0 +1,207 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultBackupSchedule  = "0 3 * * *"
+	DefaultBackupRetention = 7
+	backupScriptFile       = "backup.sh"
+)
+
+// backupIDPattern matches the ids the backup script names backups with
+var backupIDPattern = regexp.MustCompile(`^\d{8}T\d{6}Z$`)
+
+// BackupCredentialKeys are stored in the encrypted env of the app
+var BackupCredentialKeys = []string{"BACKUP_S3_ACCESS_KEY_ID", "BACKUP_S3_SECRET_ACCESS_KEY"}
+
+type backupCommands struct {
+	extension string
+	dump      string
+	restore   string
+}
+
+var databaseBackupCommands = map[string]backupCommands{
+	"postgres": {
+		extension: "sql.gz.age",
+		dump:      `docker exec -e PGPASSWORD="$POSTGRES_PASSWORD" $db_service pg_dump -U "$POSTGRES_USER" -d "$POSTGRES_DB" --clean --if-exists`,
+		restore:   `docker exec -i -e PGPASSWORD="$POSTGRES_PASSWORD" $db_service psql -U "$POSTGRES_USER" -d "$POSTGRES_DB" -v ON_ERROR_STOP=1 > /dev/null`,
+	},
+	"mysql": {
+		extension: "sql.gz.age",
+		dump:      `docker exec -e MYSQL_PWD="$MYSQL_ROOT_PASSWORD" $db_service mysqldump -u root --single-transaction --databases "$MYSQL_DATABASE"`,
+		restore:   `docker exec -i -e MYSQL_PWD="$MYSQL_ROOT_PASSWORD" $db_service mysql -u root`,
+	},
+	"redis": {
+		extension: "rdb.gz.age",
+		dump:      `docker exec $db_service sh -c 'redis-cli -a "$REDIS_PASSWORD" --no-auth-warning --rdb /tmp/sidekick-backup.rdb > /dev/null && cat /tmp/sidekick-backup.rdb && rm /tmp/sidekick-backup.rdb'`,
+		// redis only loads a dump on start, without its append only files
+		restore: `docker stop $db_service > /dev/null && docker run --rm -i -v $db_volume:/data alpine sh -c 'rm -rf /data/appendonlydir && cat > /data/dump.rdb' && docker start $db_service > /dev/null`,
+	},
+}
+
+// Backup is a backup of the database of an app stored in the bucket
+type Backup struct {
+	ID   string
+	Time string
+	Size int64
+}
+
+// backupScript renders one of the backup scripts for the database of the app
+func backupScript(template string, appConfig SidekickAppConfig, extra ...string) (string, error) {
+	backup := appConfig.DatabaseConfig.Backup
+	commands, ok := databaseBackupCommands[appConfig.DatabaseConfig.Type]
+	if !ok {
+		return "", fmt.Errorf("%s has no database to back up - run sidekick db create first", appConfig.Name)
+	}
+	if backup.BucketName == "" {
+		return "", fmt.Errorf("backups of %s are not set up - run sidekick db backup setup first", appConfig.Name)
+	}
+	endpoint := ""
+	if backup.S3Endpoint != "" {
+		endpoint = fmt.Sprintf("--endpoint-url %s", backup.S3Endpoint)
+	}
+	retention := backup.Retention
+	if retention <= 0 {
+		retention = DefaultBackupRetention
+	}
+
+	dbService := DatabaseServiceName(appConfig.Name)
+	commandReplacer := strings.NewReplacer(
+		"$db_service", dbService,
+		"$db_volume", fmt.Sprintf("sidekick_%s", databaseVolumeName(appConfig.Name)),
+	)
+	// the s3 functions are inlined first as the replacer doesn't look at what it inserts
+	script := strings.ReplaceAll(template, "$s3_functions", backupS3Functions)
+	replacer := strings.NewReplacer(append([]string{
+		"$dump_command", commandReplacer.Replace(commands.dump),
+		"$restore_command", commandReplacer.Replace(commands.restore),
+		"$bucket", backup.BucketName,
+		"$prefix", backupPrefix(appConfig),
+		"$extension", commands.extension,
+		"$retention", strconv.Itoa(retention),
+		"$region", backup.BucketRegion,
+		"$endpoint", endpoint,
+	}, extra...)...)
+	return replacer.Replace(script), nil
+}
+
+func backupPrefix(appConfig SidekickAppConfig) string {
+	return strings.Trim(strings.TrimSuffix(appConfig.DatabaseConfig.Backup.BucketPath, "/")+"/"+appConfig.Name, "/")
+}
+
+// NewBackupScript is the script cron runs to back up the database of the app
+func NewBackupScript(appConfig SidekickAppConfig) (string, error) {
+	return backupScript(BackupScript, appConfig)
+}
+
+// runBackupScript runs a script with the credentials of the app in its environment
+func runBackupScript(ctx context.Context, executor Executor, appConfig SidekickAppConfig, script string) (string, error) {
+	if err := EnsureAgeKey(ctx, executor); err != nil {
+		return "", err
+	}
+	cmd := fmt.Sprintf("cd %s && %s", appConfig.Name, SopsExecEnv("encrypted.env", "bash -s"))
+	return executor.RunWithInput(ctx, cmd, []byte(script))
+}
+
+// InstallBackupJob writes the backup script of the app and schedules it in the crontab of the sidekick user
+func InstallBackupJob(ctx context.Context, executor Executor, appConfig SidekickAppConfig) error {
+	script, err := NewBackupScript(appConfig)
+	if err != nil {
+		return err
+	}
+	if err := executor.WriteFile(ctx, fmt.Sprintf("%s/%s", appConfig.Name, backupScriptFile), []byte(script)); err != nil {
+		return err
+	}
+	schedule := appConfig.DatabaseConfig.Backup.Schedule
+	if schedule == "" {
+		schedule = DefaultBackupSchedule
+	}
+	marker := fmt.Sprintf("# sidekick backup %s", appConfig.Name)
+	job := fmt.Sprintf("%s cd $HOME/%s && %s >> backup.log 2>&1 %s", schedule, appConfig.Name, SopsExecEnv("encrypted.env", "bash "+backupScriptFile), marker)
+	cmd := fmt.Sprintf(`(crontab -l 2>/dev/null | grep -vF '%s'; echo "%s") | crontab -`, marker, job)
+	if _, err := executor.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to schedule the backups: %w", err)
+	}
+	return nil
+}
+
+// BackupNow runs the backup script of the app right away and returns the id of the backup
+func BackupNow(ctx context.Context, executor Executor, appConfig SidekickAppConfig) (string, error) {
+	script, err := NewBackupScript(appConfig)
+	if err != nil {
+		return "", err
+	}
+	output, err := runBackupScript(ctx, executor, appConfig, script)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
+
+// ListBackups returns the backups of the app in the bucket, newest first
+func ListBackups(ctx context.Context, executor Executor, appConfig SidekickAppConfig) ([]Backup, error) {
+	script, err := backupScript(ListBackupsScript, appConfig)
+	if err != nil {
+		return nil, err
+	}
+	output, err := runBackupScript(ctx, executor, appConfig, script)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBackupList(output), nil
+}
+
+// ParseBackupList reads the output of aws s3 ls into backups, newest first
+func ParseBackupList(output string) []Backup {
+	backups := []Backup{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || !strings.HasSuffix(fields[3], ".age") {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		id, _, _ := strings.Cut(fields[3], ".")
+		backups = append([]Backup{{ID: id, Time: fields[0] + " " + fields[1], Size: size}}, backups...)
+	}
+	return backups
+}
+
+// ValidBackupID reports whether id is a backup id like 20261018T030000Z
+func ValidBackupID(id string) bool {
+	return backupIDPattern.MatchString(id)
+}
+
+// RestoreBackup loads the backup with id into the database of the app
+func RestoreBackup(ctx context.Context, executor Executor, appConfig SidekickAppConfig, id string) error {
+	// the id ends up in a script run as root
+	if !ValidBackupID(id) {
+		return fmt.Errorf("invalid backup id %q, expected one like 20261018T030000Z", id)
+	}
+	script, err := backupScript(RestoreScript, appConfig, "$id", id)
+	if err != nil {
+		return err
+	}
+	_, err = runBackupScript(ctx, executor, appConfig, script)
+	return err
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CertsDir holds the certificates brought with sidekick certs add, and
+// DynamicDir the Traefik file provider configs pointing at them
+const (
+	CertsDir   = TraefikDir + "/certs"
+	DynamicDir = TraefikDir + "/dynamic"
+)
+
+// ParseCertificate checks that the key belongs to the certificate and that it
+// is valid for host today, the chain in certPEM is kept as is
+func ParseCertificate(certPEM []byte, keyPEM []byte, host string) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("the certificate and key don't match: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := certificate.VerifyHostname(host); err != nil {
+		return nil, err
+	}
+	if time.Now().After(certificate.NotAfter) {
+		return nil, fmt.Errorf("the certificate expired on %s", certificate.NotAfter.Format(time.DateOnly))
+	}
+	return certificate, nil
+}
+
+// certificateDynamicConfig is the Traefik file provider config adding the
+// certificate of host to its default store
+func certificateDynamicConfig(host string) []byte {
+	config := map[string]any{
+		"tls": map[string]any{
+			"certificates": []map[string]string{{
+				"certFile": fmt.Sprintf("/certs/%s.crt", host),
+				"keyFile":  fmt.Sprintf("/certs/%s.key", host),
+			}},
+		},
+	}
+	data, _ := yaml.Marshal(config)
+	return data
+}
+
+// InstallCertificate uploads the certificate of host and points Traefik at it,
+// Traefik watches the dynamic config so it is served without a restart
+func InstallCertificate(ctx context.Context, executor Executor, host string, certPEM []byte, keyPEM []byte) error {
+	if err := executor.WriteFile(ctx, fmt.Sprintf("%s/%s.crt", CertsDir, host), certPEM); err != nil {
+		return fmt.Errorf("failed to upload the certificate: %w", err)
+	}
+	// the key goes over stdin so a dry run never records it
+	if _, err := executor.RunWithInput(ctx, fmt.Sprintf("mkdir -p %s && umask 077 && cat > %s/%s.key", CertsDir, CertsDir, host), keyPEM); err != nil {
+		return fmt.Errorf("failed to upload the key: %w", err)
+	}
+	if err := executor.WriteFile(ctx, fmt.Sprintf("%s/%s.yml", DynamicDir, host), certificateDynamicConfig(host)); err != nil {
+		return fmt.Errorf("failed to write the Traefik config of the certificate: %w", err)
+	}
+	return nil
+}
+
+// ServedCertificate is the certificate Traefik serves for host, read on the server
+// so DNS and firewalls don't get in the way
+func ServedCertificate(ctx context.Context, executor Executor, host string) (*x509.Certificate, error) {
+	output, err := executor.Query(ctx, fmt.Sprintf("echo | openssl s_client -connect 127.0.0.1:443 -servername %s 2>/dev/null | openssl x509", host))
+	if err != nil {
+		return nil, fmt.Errorf("no certificate served for %s yet", host)
+	}
+	block, _ := pem.Decode([]byte(strings.TrimSpace(output)))
+	if block == nil {
+		return nil, fmt.Errorf("no certificate served for %s yet", host)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
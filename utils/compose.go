@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeEnvironment maps secret keys to the variables sops exec-env sets on
+// the server and adds the plain vars as they are. A var wins over a secret
+// with the same key and keys starting with _ stay out of the container
+func ComposeEnvironment(secretKeys []string, vars map[string]string) []string {
+	environment := []string{}
+	for _, key := range secretKeys {
+		if _, ok := vars[key]; ok || strings.HasPrefix(key, "_") {
+			continue
+		}
+		environment = append(environment, fmt.Sprintf("%s=${%s}", key, key))
+	}
+	for key, value := range vars {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		// compose would interpolate $ in the value
+		environment = append(environment, fmt.Sprintf("%s=%s", key, strings.ReplaceAll(value, "$", "$$")))
+	}
+	sort.Strings(environment)
+	return environment
+}
+
+// WriteAppCompose regenerates the compose file of the app on the server
+func WriteAppCompose(ctx context.Context, executor Executor, appConfig SidekickAppConfig, secretKeys []string) error {
+	composeFile, err := yaml.Marshal(AppComposeFile(appConfig, ComposeEnvironment(secretKeys, appConfig.Env.Vars)))
+	if err != nil {
+		return err
+	}
+	if err := executor.WriteFile(ctx, fmt.Sprintf("%s/docker-compose.yaml", appConfig.Name), composeFile); err != nil {
+		return fmt.Errorf("failed to update the compose file on server: %w", err)
+	}
+	return nil
+}
+
+// RestartApp replaces the running container of the app with one from the
+// current compose file, with the same zero downtime swap as deploy
+func RestartApp(ctx context.Context, executor Executor, appConfig SidekickAppConfig) (string, error) {
+	if appConfig.Env.HasEnv() {
+		return RestartWithEnv(ctx, executor, appConfig)
+	}
+	replacer := strings.NewReplacer(
+		"$service_name", appConfig.Name,
+		"$app_port", fmt.Sprint(appConfig.Port),
+	)
+	return executor.Run(ctx, replacer.Replace(DeployApp))
+}
+
+// UpdateAppService regenerates the compose file of the app and swaps the
+// running container, so changes to sidekick.yml apply without a new image
+func UpdateAppService(ctx context.Context, executor Executor, appConfig SidekickAppConfig) error {
+	secretKeys := []string{}
+	if appConfig.Env.HasEnv() {
+		env, err := ReadRemoteEnv(ctx, executor, appConfig.Name)
+		if err != nil {
+			return err
+		}
+		secretKeys = EnvKeys(env)
+	}
+	if err := EnsureTraefikEntryPoints(ctx, executor, appConfig); err != nil {
+		return err
+	}
+	if err := WriteAppCompose(ctx, executor, appConfig, secretKeys); err != nil {
+		return err
+	}
+	if _, err := RestartApp(ctx, executor, appConfig); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", appConfig.Name, err)
+	}
+	return nil
+}
+
+// AppComposeFile is the compose file of the main service of an app
+// launch writes it first and deploy regenerates it so it never drifts from the env file
+func AppComposeFile(appConfig SidekickAppConfig, environment []string) DockerComposeFile {
+	service := composeService(appConfig.Name, appConfig.Name, appConfig.AppDomains(), appConfig.certResolver(), appConfig.Middlewares, appConfig.Port, environment)
+	service.Labels = append(service.Labels, streamLabels(appConfig)...)
+	if appConfig.Wildcard {
+		// asking for the wildcard up front means previews find it ready
+		primary := appConfig.PrimaryDomain()
+		service.Labels = append(service.Labels,
+			fmt.Sprintf("traefik.http.routers.%s.tls.domains[0].main=%s", appConfig.Name, primary),
+			fmt.Sprintf("traefik.http.routers.%s.tls.domains[0].sans=*.%s", appConfig.Name, primary),
+		)
+	}
+	service.Restart = "unless-stopped"
+	appComposeFile := composeFile(appConfig.Name, service)
+	addDatabase(&appComposeFile, appConfig)
+	return appComposeFile
+}
+
+// PreviewComposeFile is the compose file of the preview of an app at a commit,
+// behind the preview auth of the app
+func PreviewComposeFile(appConfig SidekickAppConfig, deployHash string, environment []string, auth PreviewAuth) (DockerComposeFile, error) {
+	serviceName := fmt.Sprintf("%s-%s", appConfig.Name, deployHash)
+	image := fmt.Sprintf("%s:%s", appConfig.Name, deployHash)
+	domains := []SidekickAppDomainConfig{{Host: fmt.Sprintf("%s.%s", deployHash, appConfig.PrimaryDomain()), Primary: true}}
+	middlewares := SidekickAppMiddlewaresConfig{}
+	if appConfig.Middlewares.Previews {
+		middlewares = appConfig.Middlewares
+	}
+	service := composeService(serviceName, image, domains, appConfig.certResolver(), middlewares, appConfig.Port, environment)
+	if err := applyPreviewAuth(&service, serviceName, auth); err != nil {
+		return DockerComposeFile{}, err
+	}
+	return composeFile(serviceName, service), nil
+}
+
+// certResolver is the resolver of the primary domain and previews of the app
+func (c SidekickAppConfig) certResolver() string {
+	if c.Wildcard {
+		return CertResolverDNS
+	}
+	return CertResolverHTTP
+}
+
+func composeService(serviceName string, image string, domains []SidekickAppDomainConfig, certResolver string, middlewares SidekickAppMiddlewaresConfig, port uint64, environment []string) DockerService {
+	routerMiddlewares, middlewareNames := middlewareLabels(serviceName, middlewares)
+	labels := []string{"traefik.enable=true"}
+	labels = append(labels, domainLabels(serviceName, domains, certResolver, middlewareNames)...)
+	labels = append(labels, routerMiddlewares...)
+	labels = append(labels,
+		fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%d", serviceName, port),
+		"traefik.docker.network=sidekick",
+	)
+	return DockerService{
+		Image:       image,
+		Labels:      labels,
+		Environment: environment,
+		Networks: []string{
+			"sidekick",
+		},
+	}
+}
+
+func composeFile(serviceName string, service DockerService) DockerComposeFile {
+	return DockerComposeFile{
+		Services: map[string]DockerService{
+			serviceName: service,
+		},
+		Networks: map[string]DockerNetwork{
+			"sidekick": {
+				External: true,
+			},
+		},
+	}
+}
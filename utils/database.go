@@ -0,0 +1,220 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DatabaseEngine describes how sidekick runs a database next to an app
+type DatabaseEngine struct {
+	Image   string
+	Port    int
+	DataDir string
+	// EnvKeys are the keys the database container reads from the env
+	EnvKeys     []string
+	HealthCheck []string
+	Command     []string
+	// Client is the command line client sidekick db connect launches
+	Client string
+	// credentials generates the env of the database and the app
+	credentials func(host string, dbName string) map[string]string
+	// connection describes how to reach the database at addr with the credentials in env
+	connection func(env map[string]string, addr string) DatabaseConnection
+}
+
+// DatabaseConnection is how a client reaches a database. Passwords go in Env
+// rather than Args so they don't show up in the process list
+type DatabaseConnection struct {
+	URL  string
+	Args []string
+	Env  []string
+}
+
+var DatabaseEngines = map[string]DatabaseEngine{
+	"postgres": {
+		Image:       "postgres:16-alpine",
+		Port:        5432,
+		DataDir:     "/var/lib/postgresql/data",
+		EnvKeys:     []string{"POSTGRES_DB", "POSTGRES_PASSWORD", "POSTGRES_USER"},
+		HealthCheck: []string{"CMD-SHELL", "pg_isready -U $${POSTGRES_USER} -d $${POSTGRES_DB}"},
+		credentials: func(host string, dbName string) map[string]string {
+			password := generatePassword()
+			return map[string]string{
+				"POSTGRES_DB":       dbName,
+				"POSTGRES_USER":     dbName,
+				"POSTGRES_PASSWORD": password,
+				"DATABASE_URL":      fmt.Sprintf("postgres://%s:%s@%s:5432/%s", dbName, password, host, dbName),
+			}
+		},
+		Client: "psql",
+		connection: func(env map[string]string, addr string) DatabaseConnection {
+			return DatabaseConnection{
+				URL:  fmt.Sprintf("postgres://%s:%s@%s/%s", env["POSTGRES_USER"], env["POSTGRES_PASSWORD"], addr, env["POSTGRES_DB"]),
+				Args: []string{fmt.Sprintf("postgres://%s@%s/%s", env["POSTGRES_USER"], addr, env["POSTGRES_DB"])},
+				Env:  []string{"PGPASSWORD=" + env["POSTGRES_PASSWORD"]},
+			}
+		},
+	},
+	"mysql": {
+		Image:       "mysql:8.4",
+		Port:        3306,
+		DataDir:     "/var/lib/mysql",
+		EnvKeys:     []string{"MYSQL_DATABASE", "MYSQL_PASSWORD", "MYSQL_ROOT_PASSWORD", "MYSQL_USER"},
+		HealthCheck: []string{"CMD-SHELL", "mysqladmin ping -h 127.0.0.1 -u root -p$${MYSQL_ROOT_PASSWORD} --silent"},
+		credentials: func(host string, dbName string) map[string]string {
+			password := generatePassword()
+			return map[string]string{
+				"MYSQL_DATABASE":      dbName,
+				"MYSQL_USER":          dbName,
+				"MYSQL_PASSWORD":      password,
+				"MYSQL_ROOT_PASSWORD": generatePassword(),
+				"DATABASE_URL":        fmt.Sprintf("mysql://%s:%s@%s:3306/%s", dbName, password, host, dbName),
+			}
+		},
+		Client: "mysql",
+		connection: func(env map[string]string, addr string) DatabaseConnection {
+			host, port, _ := net.SplitHostPort(addr)
+			return DatabaseConnection{
+				URL:  fmt.Sprintf("mysql://%s:%s@%s/%s", env["MYSQL_USER"], env["MYSQL_PASSWORD"], addr, env["MYSQL_DATABASE"]),
+				Args: []string{"--protocol=tcp", "-h", host, "-P", port, "-u", env["MYSQL_USER"], env["MYSQL_DATABASE"]},
+				Env:  []string{"MYSQL_PWD=" + env["MYSQL_PASSWORD"]},
+			}
+		},
+	},
+	"redis": {
+		Image:       "redis:7-alpine",
+		Port:        6379,
+		DataDir:     "/data",
+		EnvKeys:     []string{"REDIS_PASSWORD"},
+		HealthCheck: []string{"CMD-SHELL", "redis-cli -a \"$${REDIS_PASSWORD}\" ping | grep PONG"},
+		Command:     []string{"redis-server", "--appendonly", "yes", "--requirepass", "${REDIS_PASSWORD}"},
+		credentials: func(host string, dbName string) map[string]string {
+			password := generatePassword()
+			return map[string]string{
+				"REDIS_PASSWORD": password,
+				"REDIS_URL":      fmt.Sprintf("redis://:%s@%s:6379/0", password, host),
+			}
+		},
+		Client: "redis-cli",
+		connection: func(env map[string]string, addr string) DatabaseConnection {
+			host, port, _ := net.SplitHostPort(addr)
+			return DatabaseConnection{
+				URL:  fmt.Sprintf("redis://:%s@%s/0", env["REDIS_PASSWORD"], addr),
+				Args: []string{"-h", host, "-p", port},
+				Env:  []string{"REDISCLI_AUTH=" + env["REDIS_PASSWORD"]},
+			}
+		},
+	},
+}
+
+var databaseNamePattern = regexp.MustCompile(`[^a-z0-9_]`)
+
+// DatabaseTypes lists the databases sidekick can run, sorted
+func DatabaseTypes() []string {
+	types := make([]string, 0, len(DatabaseEngines))
+	for dbType := range DatabaseEngines {
+		types = append(types, dbType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// DatabaseServiceName is the compose service, and host name on the sidekick network, of the database of an app
+func DatabaseServiceName(appName string) string {
+	return fmt.Sprintf("%s-db", appName)
+}
+
+func databaseVolumeName(appName string) string {
+	return fmt.Sprintf("%s-db-data", appName)
+}
+
+// DefaultDatabaseName turns an app name into a name every database accepts
+func DefaultDatabaseName(appName string) string {
+	return databaseNamePattern.ReplaceAllString(strings.ToLower(appName), "_")
+}
+
+// DatabaseCredentials generates the env the database of the app starts with
+// and the URL the app connects with
+func DatabaseCredentials(appConfig SidekickAppConfig) (map[string]string, error) {
+	engine, ok := DatabaseEngines[appConfig.DatabaseConfig.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database %q - use one of %s", appConfig.DatabaseConfig.Type, strings.Join(DatabaseTypes(), ", "))
+	}
+	return engine.credentials(DatabaseServiceName(appConfig.Name), appConfig.DatabaseConfig.DbName), nil
+}
+
+// DatabaseConnectionAt describes how to reach the database of the app at addr,
+// with the credentials from its env
+func DatabaseConnectionAt(appConfig SidekickAppConfig, env map[string]string, addr string) (DatabaseConnection, error) {
+	engine, ok := DatabaseEngines[appConfig.DatabaseConfig.Type]
+	if !ok {
+		return DatabaseConnection{}, fmt.Errorf("%s has no database - run sidekick db create first", appConfig.Name)
+	}
+	return engine.connection(env, addr), nil
+}
+
+// addDatabase adds the database of the app to its compose file, with a named
+// volume for the data, and makes the app wait for it to be healthy
+func addDatabase(composeFile *DockerComposeFile, appConfig SidekickAppConfig) {
+	engine, ok := DatabaseEngines[appConfig.DatabaseConfig.Type]
+	if !ok {
+		return
+	}
+	image := appConfig.DatabaseConfig.Image
+	if image == "" {
+		image = engine.Image
+	}
+	serviceName := DatabaseServiceName(appConfig.Name)
+	volumeName := databaseVolumeName(appConfig.Name)
+	composeFile.Services[serviceName] = DockerService{
+		Image:       image,
+		Command:     engine.Command,
+		Restart:     "unless-stopped",
+		Volumes:     []string{fmt.Sprintf("%s:%s", volumeName, engine.DataDir)},
+		Networks:    []string{"sidekick"},
+		Environment: ComposeEnvironment(engine.EnvKeys, nil),
+		HealthCheck: Healthcheck{
+			Test:     engine.HealthCheck,
+			Interval: "5s",
+			Timeout:  "5s",
+			Retries:  10,
+		},
+	}
+	if composeFile.Volumes == nil {
+		composeFile.Volumes = map[string]DockerVolume{}
+	}
+	composeFile.Volumes[volumeName] = DockerVolume{}
+
+	app := composeFile.Services[appConfig.Name]
+	app.DependsOn = map[string]DependsOn{
+		serviceName: {Condition: "service_healthy"},
+	}
+	composeFile.Services[appConfig.Name] = app
+}
+
+func generatePassword() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/mightymoud/sidekick/render"
+)
+
+// DNSResolver is the part of net.Resolver the DNS checks use
+type DNSResolver interface {
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DomainCheck is what a domain resolves to compared with the addresses of the server
+type DomainCheck struct {
+	Host  string
+	CNAME string
+	IPs   []string
+	// Foreign are the resolved addresses that aren't the server's
+	Foreign []string
+	Err     error
+}
+
+// OK means every address of the domain is the server, so the ACME challenge can reach Traefik
+func (c DomainCheck) OK() bool {
+	return c.Err == nil && len(c.IPs) > 0 && len(c.Foreign) == 0
+}
+
+// Blocking means the challenge can't reach Traefik at all
+func (c DomainCheck) Blocking() bool {
+	return c.Err != nil || len(c.IPs) == 0 || len(c.Foreign) == len(c.IPs)
+}
+
+func (c DomainCheck) Message() string {
+	via := ""
+	if c.CNAME != "" {
+		via = fmt.Sprintf(" (via CNAME %s)", c.CNAME)
+	}
+	switch {
+	case c.Err != nil:
+		return fmt.Sprintf("%s doesn't resolve: %s", c.Host, c.Err)
+	case len(c.IPs) == 0:
+		return fmt.Sprintf("%s has no A or AAAA records", c.Host)
+	case len(c.Foreign) == len(c.IPs):
+		return fmt.Sprintf("%s points to %s%s, not your VPS", c.Host, strings.Join(c.IPs, ", "), via)
+	case len(c.Foreign) > 0:
+		return fmt.Sprintf("%s also points to %s%s, which is not your VPS", c.Host, strings.Join(c.Foreign, ", "), via)
+	default:
+		return fmt.Sprintf("%s points to your VPS%s", c.Host, via)
+	}
+}
+
+// CheckDomainDNS resolves the A, AAAA and CNAME records of host and compares them with serverIPs
+func CheckDomainDNS(ctx context.Context, resolver DNSResolver, host string, serverIPs []string) DomainCheck {
+	check := DomainCheck{Host: host}
+	if cname, err := resolver.LookupCNAME(ctx, host); err == nil {
+		cname = strings.TrimSuffix(cname, ".")
+		if cname != host {
+			check.CNAME = cname
+		}
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	for _, addr := range addrs {
+		ip := addr.IP.String()
+		check.IPs = append(check.IPs, ip)
+		if !slices.Contains(serverIPs, ip) {
+			check.Foreign = append(check.Foreign, ip)
+		}
+	}
+	return check
+}
+
+// CheckDomainsDNS checks every host with the resolver of the system
+func CheckDomainsDNS(ctx context.Context, hosts []string, serverIPs []string) []DomainCheck {
+	checks := []DomainCheck{}
+	for _, host := range hosts {
+		checks = append(checks, CheckDomainDNS(ctx, net.DefaultResolver, host, serverIPs))
+	}
+	return checks
+}
+
+// PreflightDNS logs the DNS check of every host and fails if one of them
+// can't reach the server, before Traefik burns Let's Encrypt attempts on it
+func PreflightDNS(ctx context.Context, hosts []string, serverIPs []string) error {
+	blocking := []string{}
+	for _, check := range CheckDomainsDNS(ctx, hosts, serverIPs) {
+		switch {
+		case check.OK():
+			render.GetLogger(log.Options{Prefix: "DNS"}).Info(check.Message())
+		case check.Blocking():
+			render.GetLogger(log.Options{Prefix: "DNS"}).Error(check.Message())
+			blocking = append(blocking, check.Host)
+		default:
+			render.GetLogger(log.Options{Prefix: "DNS"}).Warn(check.Message())
+		}
+	}
+	if len(blocking) > 0 {
+		return fmt.Errorf("%s must point to your VPS before Traefik can get a certificate - fix your DNS records or use --skip-dns-check", strings.Join(blocking, ", "))
+	}
+	return nil
+}
+
+// ServerIPs are the addresses of the server, including IPv6 ones we don't have in the config
+func ServerIPs(ctx context.Context, executor Executor, serverAddress string) []string {
+	ips := []string{serverAddress}
+	output, err := executor.Query(ctx, "hostname -I")
+	if err != nil {
+		return ips
+	}
+	for _, ip := range strings.Fields(output) {
+		if !slices.Contains(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// VerifyCertificate waits for Traefik on the server to serve host with a
+// certificate that verifies, which fails while it still uses its default one
+func VerifyCertificate(ctx context.Context, executor Executor, host string) error {
+	cmd := fmt.Sprintf("curl -sS -o /dev/null --retry 10 --retry-delay 3 --retry-all-errors --resolve %s:443:127.0.0.1 https://%s/", host, host)
+	if _, err := executor.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("Traefik has no valid certificate for %s yet - check that it points to your VPS", host)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z0-9-]+$`)
+
+// AppDomains returns the domains of the app, falling back to its url for apps
+// launched before domains were configurable
+func (c SidekickAppConfig) AppDomains() []SidekickAppDomainConfig {
+	if len(c.Domains) == 0 {
+		if c.Url == "" {
+			return nil
+		}
+		return []SidekickAppDomainConfig{{Host: c.Url, Primary: true}}
+	}
+	return c.Domains
+}
+
+// PrimaryDomain is the domain marked primary, or the first one
+func (c SidekickAppConfig) PrimaryDomain() string {
+	return primaryDomain(c.AppDomains())
+}
+
+func primaryDomain(domains []SidekickAppDomainConfig) string {
+	for _, domain := range domains {
+		if domain.Primary {
+			return domain.Host
+		}
+	}
+	if len(domains) > 0 {
+		return domains[0].Host
+	}
+	return ""
+}
+
+// ValidateDomain checks a domain before it ends up in a Traefik rule
+func ValidateDomain(domain SidekickAppDomainConfig) error {
+	if !domainPattern.MatchString(domain.Host) {
+		return fmt.Errorf("%q is not a valid domain", domain.Host)
+	}
+	if domain.PathPrefix != "" && (!strings.HasPrefix(domain.PathPrefix, "/") || strings.ContainsAny(domain.PathPrefix, "` ")) {
+		return fmt.Errorf("path prefix %q must start with /", domain.PathPrefix)
+	}
+	if domain.Primary && domain.Redirect {
+		return fmt.Errorf("the primary domain can't redirect to itself")
+	}
+	return nil
+}
+
+// ValidateDomains checks every domain and that the primary one serves the app,
+// the redirects send visitors to it
+func ValidateDomains(domains []SidekickAppDomainConfig) error {
+	for _, domain := range domains {
+		if err := ValidateDomain(domain); err != nil {
+			return err
+		}
+	}
+	if len(domains) == 0 {
+		return nil
+	}
+	primary := primaryDomain(domains)
+	for _, domain := range domains {
+		if domain.Host == primary && !domain.Redirect {
+			return nil
+		}
+	}
+	return fmt.Errorf("the primary domain %s only redirects - add a domain that serves the app with sidekick domains add --primary", primary)
+}
+
+// routerName keeps the service name for the primary domain so apps launched
+// before domains were configurable keep their router
+func routerName(serviceName string, domain SidekickAppDomainConfig, primary bool) string {
+	if primary {
+		return serviceName
+	}
+	name := strings.ReplaceAll(domain.Host, ".", "-")
+	if domain.PathPrefix != "" {
+		name += strings.ReplaceAll(domain.PathPrefix, "/", "-")
+	}
+	return fmt.Sprintf("%s-%s", serviceName, strings.TrimSuffix(name, "-"))
+}
+
+// domainLabels are the Traefik router labels of the service for each domain,
+// with a redirect middleware for the domains that send visitors to the primary one.
+// The primary domain gets its certificate from primaryResolver, the others over HTTP
+// unless they have a custom one, and the routers serving the app run middlewares
+func domainLabels(serviceName string, domains []SidekickAppDomainConfig, primaryResolver string, middlewares []string) []string {
+	primary := primaryDomain(domains)
+	labels := []string{}
+	for _, domain := range domains {
+		isPrimary := domain.Host == primary && !domain.Redirect
+		router := routerName(serviceName, domain, isPrimary)
+		resolver := CertResolverHTTP
+		if isPrimary {
+			resolver = primaryResolver
+		}
+		rule := fmt.Sprintf("Host(`%s`)", domain.Host)
+		if domain.PathPrefix != "" {
+			rule += fmt.Sprintf(" && PathPrefix(`%s`)", domain.PathPrefix)
+		}
+		labels = append(labels,
+			fmt.Sprintf("traefik.http.routers.%s.rule=%s", router, rule),
+			fmt.Sprintf("traefik.http.routers.%s.service=%s", router, serviceName),
+			fmt.Sprintf("traefik.http.routers.%s.tls=true", router),
+		)
+		if !domain.CustomCert {
+			labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=%s", router, resolver))
+		}
+		if domain.Redirect && domain.Host != primary {
+			middleware := router + "-redirect"
+			labels = append(labels,
+				// $$ keeps compose from interpolating the capture group
+				fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.regex=^https?://[^/]+/(.*)", middleware),
+				fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.replacement=https://%s/$${1}", middleware, primary),
+				fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.permanent=true", middleware),
+				fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", router, middleware),
+			)
+		} else if len(middlewares) > 0 {
+			labels = append(labels, fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", router, strings.Join(middlewares, ",")))
+		}
+	}
+	return labels
+}
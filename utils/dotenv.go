@@ -0,0 +1,174 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+var (
+	envKeyPattern        = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_.-]*)\s*=\s*(.*)$`)
+	envPlainValuePattern = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+)
+
+// FormatEnvValue quotes value so godotenv parses it back unchanged
+// It tries the plainest form first and checks each one by parsing it
+func FormatEnvValue(value string) (string, error) {
+	candidates := []string{}
+	if envPlainValuePattern.MatchString(value) {
+		candidates = append(candidates, value)
+	}
+	doubleQuoted := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"$", `\$`,
+	).Replace(value)
+	candidates = append(candidates,
+		`"`+doubleQuoted+`"`,
+		`'`+value+`'`,
+		strings.ReplaceAll(value, "$", `\$`),
+	)
+	for _, candidate := range candidates {
+		parsed, err := godotenv.Unmarshal("KEY=" + candidate)
+		if err == nil && parsed["KEY"] == value {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("the value can't be written to a dotenv file")
+}
+
+// MarshalEnv writes env as dotenv lines sorted by key
+func MarshalEnv(env map[string]string) (string, error) {
+	lines := []string{}
+	for _, key := range EnvKeys(env) {
+		value, err := FormatEnvValue(env[key])
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key, err)
+		}
+		lines = append(lines, fmt.Sprintf("%s=%s", key, value))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// WriteEnvFile makes filename hold exactly env. When the file exists its
+// comments, order and the formatting of unchanged values are kept, removed
+// keys are dropped and new keys are appended sorted. New files are only
+// readable by the current user as they hold secrets
+func WriteEnvFile(filename string, env map[string]string) error {
+	existing, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+
+	// values are compared in the context of the whole file so references to other keys stay as they are
+	current, _ := godotenv.Unmarshal(string(existing))
+	out := []string{}
+	written := map[string]bool{}
+	for _, segment := range splitEnvFile(string(existing)) {
+		if segment.key == "" {
+			out = append(out, segment.text)
+			continue
+		}
+		value, ok := env[segment.key]
+		if !ok {
+			continue
+		}
+		written[segment.key] = true
+		if currentValue, ok := current[segment.key]; ok && currentValue == value {
+			out = append(out, segment.text)
+			continue
+		}
+		formatted, err := FormatEnvValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", segment.key, err)
+		}
+		out = append(out, fmt.Sprintf("%s=%s", segment.key, formatted))
+	}
+
+	added := map[string]string{}
+	for key, value := range env {
+		if !written[key] {
+			added[key] = value
+		}
+	}
+	if len(added) > 0 {
+		content, err := MarshalEnv(added)
+		if err != nil {
+			return err
+		}
+		out = append(out, content)
+	}
+
+	content := strings.TrimRight(strings.Join(out, "\n"), "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
+
+// envSegment is a variable of an env file, which can span several lines when
+// its value is quoted, or a comment or blank line when key is empty
+type envSegment struct {
+	key  string
+	text string
+}
+
+func splitEnvFile(content string) []envSegment {
+	segments := []envSegment{}
+	if content == "" {
+		return segments
+	}
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		match := envKeyPattern.FindStringSubmatch(strings.TrimSpace(lines[i]))
+		if match == nil || strings.HasPrefix(strings.TrimSpace(lines[i]), "#") {
+			segments = append(segments, envSegment{text: lines[i]})
+			continue
+		}
+		end := i
+		if value := match[2]; value != "" && (value[0] == '"' || value[0] == '\'') && !closesQuote(value[1:], value[0]) {
+			for end+1 < len(lines) {
+				end++
+				if closesQuote(lines[end], value[0]) {
+					break
+				}
+			}
+		}
+		segments = append(segments, envSegment{key: match[1], text: strings.Join(lines[i:end+1], "\n")})
+		i = end
+	}
+	return segments
+}
+
+// closesQuote tells if s has a quote that isn't escaped, like godotenv looks for it
+func closesQuote(s string, quote byte) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == quote && (i == 0 || s[i-1] != '\\') {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/joho/godotenv"
+)
+
+// LoadEnvFiles reads the env files in order, a key in a later file wins
+func LoadEnvFiles(files []string) (map[string]string, error) {
+	env := map[string]string{}
+	for _, file := range files {
+		fileEnv, err := godotenv.Read(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file %s: %w", file, err)
+		}
+		for key, value := range fileEnv {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// AppEnv is the secret env the app runs with: its env files and the keys
+// managed on the server, taken from serverEnv
+func AppEnv(envConfig SidekickAppEnvConfig, serverEnv map[string]string) (map[string]string, error) {
+	env, err := LoadEnvFiles(envConfig.EnvFiles())
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range envConfig.Managed {
+		if value, ok := serverEnv[key]; ok {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// PreviewEnv layers the preview env files over AppEnv so previews can point
+// to other services without touching the secrets of the app
+func PreviewEnv(envConfig SidekickAppEnvConfig, serverEnv map[string]string) (map[string]string, error) {
+	env, err := AppEnv(envConfig, serverEnv)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := LoadEnvFiles(envConfig.Preview.Files)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range overrides {
+		env[key] = value
+	}
+	return env, nil
+}
+
+// Manage records keys as managed on the server, keeping the list sorted
+func (e *SidekickAppEnvConfig) Manage(keys ...string) {
+	for _, key := range keys {
+		if !slices.Contains(e.Managed, key) {
+			e.Managed = append(e.Managed, key)
+		}
+	}
+	sort.Strings(e.Managed)
+}
+
+// Unmanage hands keys back to the env files
+func (e *SidekickAppEnvConfig) Unmanage(keys ...string) {
+	e.Managed = slices.DeleteFunc(e.Managed, func(key string) bool {
+		return slices.Contains(keys, key)
+	})
+}
+
+// SetLocalEnv writes values to the last env file of the app, the one that wins
+func SetLocalEnv(envConfig SidekickAppEnvConfig, values map[string]string) error {
+	files := envConfig.EnvFiles()
+	if len(files) == 0 {
+		return fmt.Errorf("no env file in sidekick.yml to write to")
+	}
+	file := files[len(files)-1]
+	env := map[string]string{}
+	if FileExists(file) {
+		current, err := godotenv.Read(file)
+		if err != nil {
+			return fmt.Errorf("failed to read env file %s: %w", file, err)
+		}
+		env = current
+	}
+	for key, value := range values {
+		env[key] = value
+	}
+	return WriteEnvFile(file, env)
+}
+
+// UnsetLocalEnv removes keys from every env file of the app
+func UnsetLocalEnv(envConfig SidekickAppEnvConfig, keys []string) error {
+	for _, file := range envConfig.EnvFiles() {
+		if !FileExists(file) {
+			continue
+		}
+		env, err := godotenv.Read(file)
+		if err != nil {
+			return fmt.Errorf("failed to read env file %s: %w", file, err)
+		}
+		changed := false
+		for _, key := range keys {
+			if _, ok := env[key]; ok {
+				delete(env, key)
+				changed = true
+			}
+		}
+		if changed {
+			if err := WriteEnvFile(file, env); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
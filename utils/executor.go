@@ -0,0 +1,218 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/mightymoud/sidekick/render"
+	"golang.org/x/crypto/ssh"
+)
+
+// Executor is how commands act on the VPS. Everything that changes the
+// server goes through it so a dry run can record the actions instead
+type Executor interface {
+	// Query runs a read only command, it runs on the server even in a dry run
+	Query(ctx context.Context, cmd string) (string, error)
+	// Run runs cmd and returns its stdout
+	Run(ctx context.Context, cmd string) (string, error)
+	// Stream runs cmd and sends its output to p as it comes
+	Stream(ctx context.Context, cmd string, p render.Output) error
+	// Upload copies a local file into remoteDir
+	Upload(ctx context.Context, localPath string, remoteDir string, p render.Output) error
+	// RunWithInput runs cmd with input on its stdin, input is never recorded
+	RunWithInput(ctx context.Context, cmd string, input []byte) (string, error)
+	// WriteFile replaces remotePath with content
+	WriteFile(ctx context.Context, remotePath string, content []byte) error
+	// Local runs a step on this machine, like building the image
+	Local(description string, run func() error) error
+	DryRun() bool
+}
+
+// NewExecutor returns an executor acting on client, or recording into plan
+// when plan is not nil
+func NewExecutor(client *ssh.Client, plan *Plan) Executor {
+	if client == nil {
+		return NewLocalExecutor(plan)
+	}
+	sshExecutor := NewSSHExecutor(client)
+	if plan == nil {
+		return sshExecutor
+	}
+	return NewDryRunExecutor(sshExecutor, plan, client.User())
+}
+
+// NewLocalExecutor is for steps that happen before we are logged in,
+// it can only run Local steps
+func NewLocalExecutor(plan *Plan) Executor {
+	if plan == nil {
+		return &SSHExecutor{}
+	}
+	return NewDryRunExecutor(nil, plan, "")
+}
+
+// StreamCommands runs the commands one after the other and stops at the first failure
+func StreamCommands(ctx context.Context, executor Executor, commands []string, p render.Output) error {
+	for _, cmd := range commands {
+		if err := executor.Stream(ctx, cmd, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type SSHExecutor struct {
+	client *ssh.Client
+}
+
+func NewSSHExecutor(client *ssh.Client) *SSHExecutor {
+	return &SSHExecutor{client: client}
+}
+
+func (e *SSHExecutor) Query(ctx context.Context, cmd string) (string, error) {
+	return RunCommandOutput(ctx, e.client, cmd)
+}
+
+func (e *SSHExecutor) Run(ctx context.Context, cmd string) (string, error) {
+	return RunCommandOutput(ctx, e.client, cmd)
+}
+
+func (e *SSHExecutor) Stream(ctx context.Context, cmd string, p render.Output) error {
+	return RunCommandWithTUIHookContext(ctx, e.client, cmd, p)
+}
+
+func (e *SSHExecutor) Upload(ctx context.Context, localPath string, remoteDir string, p render.Output) error {
+	host, _, err := net.SplitHostPort(e.client.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	uploadCmd := exec.CommandContext(ctx, "scp", "-C", localPath, fmt.Sprintf("%s@%s:%s", e.client.User(), host, remoteDir))
+	if p != nil {
+		uploadCmdErrPipe, _ := uploadCmd.StderrPipe()
+		go render.SendLogsToTUI(uploadCmdErrPipe, p)
+	}
+	if err := uploadCmd.Run(); err != nil {
+		return fmt.Errorf("failed to upload %s to %s: %w", localPath, remoteDir, err)
+	}
+	return nil
+}
+
+func (e *SSHExecutor) RunWithInput(ctx context.Context, cmd string, input []byte) (string, error) {
+	return RunCommandInput(ctx, e.client, cmd, input)
+}
+
+func (e *SSHExecutor) WriteFile(ctx context.Context, remotePath string, content []byte) error {
+	_, err := RunCommandInput(ctx, e.client, fmt.Sprintf("mkdir -p %s && cat > %s", path.Dir(remotePath), remotePath), content)
+	return err
+}
+
+func (e *SSHExecutor) Local(description string, run func() error) error {
+	return run()
+}
+
+func (e *SSHExecutor) DryRun() bool {
+	return false
+}
+
+const (
+	ActionLocal  = "local"
+	ActionRemote = "remote"
+	ActionUpload = "upload"
+	ActionWrite  = "write"
+)
+
+// PlannedAction is something a dry run would have done
+type PlannedAction struct {
+	Kind    string `json:"kind"`
+	User    string `json:"user,omitempty"`
+	Command string `json:"command"`
+	// Path and Content are set for files we can show, like compose files
+	Path    string `json:"path,omitempty"`
+	Content string `json:"content,omitempty"`
+	Current string `json:"-"`
+}
+
+// DryRunExecutor records every change into a Plan and only runs queries
+type DryRunExecutor struct {
+	query Executor
+	plan  *Plan
+	user  string
+}
+
+// NewDryRunExecutor records into plan, query can be nil when there is
+// nothing to read from yet, like a user that doesn't exist before init
+func NewDryRunExecutor(query Executor, plan *Plan, user string) *DryRunExecutor {
+	return &DryRunExecutor{query: query, plan: plan, user: user}
+}
+
+func (e *DryRunExecutor) Query(ctx context.Context, cmd string) (string, error) {
+	if e.query == nil {
+		return "", nil
+	}
+	return e.query.Query(ctx, cmd)
+}
+
+func (e *DryRunExecutor) Run(ctx context.Context, cmd string) (string, error) {
+	e.plan.add(PlannedAction{Kind: ActionRemote, User: e.user, Command: cmd})
+	return "", nil
+}
+
+func (e *DryRunExecutor) RunWithInput(ctx context.Context, cmd string, input []byte) (string, error) {
+	e.plan.add(PlannedAction{Kind: ActionRemote, User: e.user, Command: cmd})
+	return "", nil
+}
+
+func (e *DryRunExecutor) Stream(ctx context.Context, cmd string, p render.Output) error {
+	e.plan.add(PlannedAction{Kind: ActionRemote, User: e.user, Command: cmd})
+	return nil
+}
+
+func (e *DryRunExecutor) Upload(ctx context.Context, localPath string, remoteDir string, p render.Output) error {
+	action := PlannedAction{Kind: ActionUpload, User: e.user, Command: fmt.Sprintf("%s -> %s/", localPath, strings.TrimSuffix(remoteDir, "/"))}
+	if isShownFile(localPath) {
+		if content, err := readSmallFile(localPath); err == nil {
+			action.Path = path.Join(remoteDir, path.Base(localPath))
+			action.Content = content
+			action.Current = ReadRemoteFile(ctx, e, action.Path)
+		}
+	}
+	e.plan.add(action)
+	return nil
+}
+
+func (e *DryRunExecutor) WriteFile(ctx context.Context, remotePath string, content []byte) error {
+	action := PlannedAction{Kind: ActionWrite, User: e.user, Command: remotePath}
+	if isShownFile(remotePath) {
+		action.Path = remotePath
+		action.Content = string(content)
+		action.Current = ReadRemoteFile(ctx, e, remotePath)
+	}
+	e.plan.add(action)
+	return nil
+}
+
+func (e *DryRunExecutor) Local(description string, run func() error) error {
+	e.plan.add(PlannedAction{Kind: ActionLocal, Command: description})
+	return nil
+}
+
+func (e *DryRunExecutor) DryRun() bool {
+	return true
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	OutcomeSuccess   = "success"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// HistoryEntry is one line of ~/<app>/history.jsonl on the server
+type HistoryEntry struct {
+	Time        string `json:"time"`
+	Action      string `json:"action"`
+	Version     string `json:"version,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	GitSHA      string `json:"gitSha,omitempty"`
+	EnvHash     string `json:"envHash,omitempty"`
+	User        string `json:"user"`
+	Host        string `json:"host"`
+	DurationMs  int64  `json:"durationMs"`
+	Outcome     string `json:"outcome"`
+	Message     string `json:"message,omitempty"`
+	started     time.Time
+}
+
+func historyFile(appName string) string {
+	return fmt.Sprintf("%s/history.jsonl", appName)
+}
+
+// NewHistoryEntry starts an entry for the given action with the current user and commit
+func NewHistoryEntry(action string) *HistoryEntry {
+	host, _ := os.Hostname()
+	gitSHA := ""
+	if output, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		gitSHA = strings.TrimSpace(string(output))
+	}
+	now := time.Now()
+	return &HistoryEntry{
+		Time:    now.Format(time.RFC3339),
+		Action:  action,
+		GitSHA:  gitSHA,
+		User:    lockOwner(),
+		Host:    host,
+		started: now,
+	}
+}
+
+// Finish sets the outcome and duration of the entry from the result of the action
+func (h *HistoryEntry) Finish(err error) {
+	h.DurationMs = time.Since(h.started).Milliseconds()
+	h.Outcome = OutcomeSuccess
+	if err != nil {
+		h.Outcome = OutcomeFailed
+		h.Message = err.Error()
+	}
+}
+
+// Elapsed is how long the recorded action took
+func (h HistoryEntry) Elapsed() time.Duration {
+	return time.Duration(h.DurationMs) * time.Millisecond
+}
+
+func AppendHistory(ctx context.Context, executor Executor, appName string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(append(line, '\n'))
+	_, err = executor.Run(ctx, fmt.Sprintf("mkdir -p %s && echo %s | base64 -d >> %s", appName, encoded, historyFile(appName)))
+	return err
+}
+
+// ReadHistory returns the recorded entries of the app, oldest first
+// Lines that can't be parsed are skipped so one bad write doesn't hide the rest
+func ReadHistory(ctx context.Context, executor Executor, appName string) ([]HistoryEntry, error) {
+	output, err := executor.Query(ctx, fmt.Sprintf(`[ -f %s ] && cat %s || true`, historyFile(appName), historyFile(appName)))
+	if err != nil {
+		return nil, err
+	}
+	entries := []HistoryEntry{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// LastHistory keeps the limit most recent entries, oldest first. A limit of 0 keeps them all
+func LastHistory(entries []HistoryEntry, limit int) []HistoryEntry {
+	if limit > 0 && len(entries) > limit {
+		return entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// ImageDigest returns the content addressed id of an image loaded on the server
+func ImageDigest(ctx context.Context, executor Executor, image string) string {
+	output, err := executor.Query(ctx, fmt.Sprintf("docker image inspect --format '{{.Id}}' %s", image))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
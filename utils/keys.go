@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/mightymoud/sidekick/render"
+	"github.com/spf13/viper"
+)
+
+// RemoteEnvFile is a decrypted encrypted.env of an app or preview on the server
+type RemoteEnvFile struct {
+	Path       string
+	Env        map[string]string
+	Recipients []string
+}
+
+var sopsRecipientPattern = regexp.MustCompile(`^sops_age__list_\d+__map_recipient=(.+)$`)
+
+// Recipients are the named age public keys, besides the VPS, that env files are encrypted for
+func Recipients() map[string]string {
+	return viper.GetStringMapString("recipients")
+}
+
+// AgeRecipients returns every public key env files get encrypted for: the
+// recipients in config, this machine, the VPS and any extra ones
+func AgeRecipients(extra ...string) []string {
+	keys := []string{viper.GetString("publicKey"), viper.GetString("serverPublicKey")}
+	for _, key := range Recipients() {
+		keys = append(keys, key)
+	}
+	keys = append(keys, extra...)
+
+	unique := []string{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key != "" && !slices.Contains(unique, key) {
+			unique = append(unique, key)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// FileRecipients lists the age recipients recorded in a sops dotenv file
+func FileRecipients(encrypted []byte) []string {
+	recipients := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(encrypted)))
+	for scanner.Scan() {
+		if match := sopsRecipientPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text())); match != nil {
+			recipients = append(recipients, match[1])
+		}
+	}
+	return recipients
+}
+
+// GenerateAgeKey makes a new age identity and returns its public and secret keys
+func GenerateAgeKey() (string, string, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate an age key: %w", err)
+	}
+	return identity.Recipient().String(), identity.String(), nil
+}
+
+// ServerPublicKey returns the public key of the age identity stored on the VPS
+func ServerPublicKey(ctx context.Context, executor Executor) (string, error) {
+	return serverPublicKeyOf(ctx, executor, AgeKeyFile)
+}
+
+func serverPublicKeyOf(ctx context.Context, executor Executor, file string) (string, error) {
+	output, err := executor.Query(ctx, fmt.Sprintf("sudo age-keygen -y %s", file))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the public key of your VPS: %w", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// newServerAgeKey generates the next age key of the VPS next to the current
+// one and returns its public key, promoteServerAgeKey puts it in use
+func newServerAgeKey(ctx context.Context, executor Executor) (string, error) {
+	if _, err := executor.Run(ctx, generateAgeKeyCmd(AgeKeyFile+".new")); err != nil {
+		return "", fmt.Errorf("failed to generate a new age key on your VPS: %w", err)
+	}
+	return serverPublicKeyOf(ctx, executor, AgeKeyFile+".new")
+}
+
+// promoteServerAgeKey replaces the age key of the VPS with the one made by
+// newServerAgeKey. The old key is kept in RetiredAgeKeysFile
+func promoteServerAgeKey(ctx context.Context, executor Executor) error {
+	cmd := fmt.Sprintf("sudo sh -c 'umask 077 && cat %s >> %s && mv %s.new %s'", AgeKeyFile, RetiredAgeKeysFile, AgeKeyFile, AgeKeyFile)
+	if _, err := executor.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to install the new age key on your VPS: %w", err)
+	}
+	return nil
+}
+
+// RotateServerKey gives the VPS a new age key and re-encrypts envFiles for it
+// instead of the old one. If this machine shares its key with the VPS, as it
+// did on servers set up by older versions, it gets a key of its own
+func RotateServerKey(ctx context.Context, executor Executor, envFiles []RemoteEnvFile) error {
+	oldPublicKey, err := ServerPublicKey(ctx, executor)
+	if err != nil {
+		return err
+	}
+	newPublicKey, err := newServerAgeKey(ctx, executor)
+	if err != nil {
+		return err
+	}
+	extra := []string{newPublicKey}
+	sharedKey := viper.GetString("publicKey") == oldPublicKey
+	var newLocalPublicKey, newLocalSecretKey string
+	if sharedKey {
+		newLocalPublicKey, newLocalSecretKey, err = GenerateAgeKey()
+		if err != nil {
+			return err
+		}
+		render.RegisterSecret(newLocalSecretKey)
+		extra = append(extra, newLocalPublicKey)
+	}
+
+	// encrypt for both keys first so the VPS can decrypt whatever happens next
+	if err := WriteAllRemoteEnvs(ctx, executor, envFiles, nil, extra...); err != nil {
+		return err
+	}
+	if err := promoteServerAgeKey(ctx, executor); err != nil {
+		return err
+	}
+	if sharedKey {
+		viper.Set("publicKey", newLocalPublicKey)
+		viper.Set("secretKey", newLocalSecretKey)
+	}
+	viper.Set("serverPublicKey", newPublicKey)
+	if err := executor.Local("save the new keys to your sidekick config", viper.WriteConfig); err != nil {
+		return err
+	}
+	for i := range envFiles {
+		envFiles[i].Recipients = append(envFiles[i].Recipients, extra...)
+	}
+	return WriteAllRemoteEnvs(ctx, executor, envFiles, []string{oldPublicKey})
+}
+
+// ListRemoteEnvFiles finds the encrypted.env of every app and preview on the server
+func ListRemoteEnvFiles(ctx context.Context, executor Executor) ([]string, error) {
+	output, err := executor.Query(ctx, "ls -1 */encrypted.env */preview/*/encrypted.env 2>/dev/null || true")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// WithEnvLocks runs fn while holding the lock of every app with an env file on
+// the server, so no deploy or env set writes one while its recipients change
+func WithEnvLocks(ctx context.Context, executor Executor, command string, fn func() error) error {
+	files, err := ListRemoteEnvFiles(ctx, executor)
+	if err != nil {
+		return err
+	}
+	apps := []string{}
+	for _, file := range files {
+		if appName := strings.Split(file, "/")[0]; !slices.Contains(apps, appName) {
+			apps = append(apps, appName)
+		}
+	}
+	return WithLocks(ctx, executor, apps, command, fn)
+}
+
+// ReadAllRemoteEnvs decrypts every env file on the server with the key of this
+// machine. Call it inside WithEnvLocks when the files are written back
+func ReadAllRemoteEnvs(ctx context.Context, executor Executor) ([]RemoteEnvFile, error) {
+	files, err := ListRemoteEnvFiles(ctx, executor)
+	if err != nil {
+		return nil, err
+	}
+	envFiles := []RemoteEnvFile{}
+	for _, file := range files {
+		encrypted := ReadRemoteFile(ctx, executor, file)
+		env, err := DecryptEnv([]byte(encrypted))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w - is this machine one of its recipients?", file, err)
+		}
+		envFiles = append(envFiles, RemoteEnvFile{Path: file, Env: env, Recipients: FileRecipients([]byte(encrypted))})
+	}
+	return envFiles, nil
+}
+
+// WriteAllRemoteEnvs encrypts the files for their current recipients plus
+// AgeRecipients and extra, without the keys in drop
+func WriteAllRemoteEnvs(ctx context.Context, executor Executor, envFiles []RemoteEnvFile, drop []string, extra ...string) error {
+	for _, envFile := range envFiles {
+		recipients := slices.DeleteFunc(AgeRecipients(append(slices.Clone(envFile.Recipients), extra...)...), func(key string) bool {
+			return slices.Contains(drop, key)
+		})
+		encrypted, err := EncryptEnv(envFile.Env, recipients)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envFile.Path, err)
+		}
+		if err := executor.WriteFile(ctx, envFile.Path, encrypted); err != nil {
+			return fmt.Errorf("%s: %w", envFile.Path, err)
+		}
+	}
+	return nil
+}
+
+// AppsOf returns the app names the env files belong to
+func AppsOf(envFiles []RemoteEnvFile) []string {
+	apps := []string{}
+	for _, envFile := range envFiles {
+		appName := strings.Split(envFile.Path, "/")[0]
+		if !slices.Contains(apps, appName) {
+			apps = append(apps, appName)
+		}
+	}
+	return apps
+}
@@ -0,0 +1,194 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DeployLock is stored in ~/<app>/.sidekick.lock on the server while a
+// command is changing the app so two people can't deploy at the same time
+type DeployLock struct {
+	Owner      string `json:"owner"`
+	Host       string `json:"host"`
+	PID        int    `json:"pid"`
+	Command    string `json:"command"`
+	AcquiredAt string `json:"acquiredAt"`
+}
+
+// LockHeldError is returned when somebody else holds the lock of the app
+type LockHeldError struct {
+	Lock DeployLock
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("%s is already running on this app - started by %s on %s (pid %d) at %s. If that run is gone, remove the lock with sidekick unlock --force",
+		e.Lock.Command, e.Lock.Owner, e.Lock.Host, e.Lock.PID, e.Lock.AcquiredAt)
+}
+
+func lockFile(appName string) string {
+	return fmt.Sprintf("%s/.sidekick.lock", appName)
+}
+
+// NewDeployLock describes the current process as the lock owner
+func NewDeployLock(command string) DeployLock {
+	host, _ := os.Hostname()
+	return DeployLock{
+		Owner:      lockOwner(),
+		Host:       host,
+		PID:        os.Getpid(),
+		Command:    command,
+		AcquiredAt: time.Now().Format(time.RFC3339),
+	}
+}
+
+// lockOwner prefers the git email so teammates can tell who is deploying
+func lockOwner() string {
+	if email, err := exec.Command("git", "config", "user.email").Output(); err == nil && strings.TrimSpace(string(email)) != "" {
+		return strings.TrimSpace(string(email))
+	}
+	if currentUser, err := user.Current(); err == nil {
+		return currentUser.Username
+	}
+	return "unknown"
+}
+
+// IsOwnedByMe tells if the lock was taken by the current user on this machine
+func (l DeployLock) IsOwnedByMe() bool {
+	host, _ := os.Hostname()
+	return l.Owner == lockOwner() && l.Host == host
+}
+
+// IsStale tells if the lock was left behind by a run of ours on this
+// machine that is no longer running
+func (l DeployLock) IsStale() bool {
+	return l.IsOwnedByMe() && !processRunning(l.PID)
+}
+
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	// EPERM means it exists but belongs to someone else, anything we can't
+	// tell apart from a live process counts as running
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
+
+// AcquireLock creates the lock file of the app with noclobber so only one
+// command can hold it. It returns a *LockHeldError when it's taken
+func AcquireLock(ctx context.Context, executor Executor, appName string, lock DeployLock) error {
+	if executor.DryRun() {
+		held, err := ReadLock(ctx, executor, appName)
+		if err != nil {
+			return err
+		}
+		if held != nil {
+			return &LockHeldError{Lock: *held}
+		}
+		return nil
+	}
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(content)
+	cmd := fmt.Sprintf(`mkdir -p %s && (set -o noclobber; echo %s | base64 -d > %s) 2>/dev/null && echo "acquired" || cat %s`,
+		appName, encoded, lockFile(appName), lockFile(appName))
+	output, err := executor.Run(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to acquire the deploy lock: %w", err)
+	}
+	if strings.TrimSpace(output) == "acquired" {
+		return nil
+	}
+	held := DeployLock{}
+	if err := json.Unmarshal([]byte(output), &held); err != nil {
+		return fmt.Errorf("the deploy lock of %s is corrupted, remove it with sidekick unlock --force", appName)
+	}
+	return &LockHeldError{Lock: held}
+}
+
+// ReadLock returns the current lock of the app or nil when it's free
+func ReadLock(ctx context.Context, executor Executor, appName string) (*DeployLock, error) {
+	output, err := executor.Query(ctx, fmt.Sprintf(`[ -f %s ] && cat %s || true`, lockFile(appName), lockFile(appName)))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(output) == "" {
+		return nil, nil
+	}
+	lock := DeployLock{}
+	if err := json.Unmarshal([]byte(output), &lock); err != nil {
+		return nil, fmt.Errorf("the deploy lock of %s is corrupted: %w", appName, err)
+	}
+	return &lock, nil
+}
+
+// ReleaseLock removes the lock only if it is still the one we acquired
+func ReleaseLock(ctx context.Context, executor Executor, appName string, lock DeployLock) error {
+	current, err := ReadLock(ctx, executor, appName)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+	if *current != lock {
+		return errors.New("the deploy lock was taken over by someone else, leaving it in place")
+	}
+	return ForceUnlock(ctx, executor, appName)
+}
+
+func ForceUnlock(ctx context.Context, executor Executor, appName string) error {
+	_, err := executor.Run(ctx, fmt.Sprintf("rm -f %s", lockFile(appName)))
+	return err
+}
+
+// WithLock runs fn while holding the deploy lock of the app
+func WithLock(ctx context.Context, executor Executor, appName string, command string, fn func() error) error {
+	return WithLocks(ctx, executor, []string{appName}, command, fn)
+}
+
+// WithLocks runs fn while holding the deploy locks of all the apps, for
+// commands that change every app at once. The locks taken are released when
+// one of them is held by somebody else
+func WithLocks(ctx context.Context, executor Executor, appNames []string, command string, fn func() error) error {
+	lock := NewDeployLock(command)
+	acquired := []string{}
+	defer func() {
+		for _, appName := range acquired {
+			ReleaseLock(context.Background(), executor, appName, lock)
+		}
+	}()
+	for _, appName := range appNames {
+		if err := AcquireLock(ctx, executor, appName, lock); err != nil {
+			return err
+		}
+		acquired = append(acquired, appName)
+	}
+	return fn()
+}
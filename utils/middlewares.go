@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	envNamePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidateMiddlewares checks the middlewares before they end up in Traefik labels
+func ValidateMiddlewares(middlewares SidekickAppMiddlewaresConfig) error {
+	if middlewares.BasicAuth != "" && !envNamePattern.MatchString(middlewares.BasicAuth) {
+		return fmt.Errorf("basicAuth must be the env key holding the users, not %q", middlewares.BasicAuth)
+	}
+	for _, headers := range []map[string]string{middlewares.RequestHeaders, middlewares.ResponseHeaders} {
+		for name := range headers {
+			if !headerNamePattern.MatchString(name) {
+				return fmt.Errorf("%q is not a valid header name", name)
+			}
+		}
+	}
+	if middlewares.RateLimit.Average < 0 || middlewares.RateLimit.Burst < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+	return nil
+}
+
+// middlewareLabels defines the middlewares of a service and returns the
+// labels with the names to attach to its routers, in the order they run
+func middlewareLabels(serviceName string, middlewares SidekickAppMiddlewaresConfig) ([]string, []string) {
+	labels := []string{}
+	names := []string{}
+	define := func(kind string, options ...string) {
+		name := fmt.Sprintf("%s-%s", serviceName, kind)
+		for _, option := range options {
+			labels = append(labels, fmt.Sprintf("traefik.http.middlewares.%s.%s", name, option))
+		}
+		names = append(names, name)
+	}
+
+	if len(middlewares.IPAllowList) > 0 {
+		define("ipallowlist", "ipallowlist.sourcerange="+strings.Join(middlewares.IPAllowList, ","))
+	}
+	if middlewares.RateLimit.Average > 0 {
+		options := []string{fmt.Sprintf("ratelimit.average=%d", middlewares.RateLimit.Average)}
+		if middlewares.RateLimit.Burst > 0 {
+			options = append(options, fmt.Sprintf("ratelimit.burst=%d", middlewares.RateLimit.Burst))
+		}
+		define("ratelimit", options...)
+	}
+	if middlewares.BasicAuth != "" {
+		// compose fills in the users from the env sops exec-env sets
+		define("basicauth", fmt.Sprintf("basicauth.users=${%s}", middlewares.BasicAuth))
+	}
+
+	headers := []string{}
+	if middlewares.SecurityHeaders {
+		headers = append(headers,
+			"headers.stsSeconds=31536000",
+			"headers.stsIncludeSubdomains=true",
+			"headers.contentTypeNosniff=true",
+			"headers.frameDeny=true",
+			"headers.browserXssFilter=true",
+			"headers.referrerPolicy=strict-origin-when-cross-origin",
+		)
+	}
+	headers = append(headers, headerLabels("customrequestheaders", middlewares.RequestHeaders)...)
+	headers = append(headers, headerLabels("customresponseheaders", middlewares.ResponseHeaders)...)
+	if len(headers) > 0 {
+		define("headers", headers...)
+	}
+
+	if middlewares.Compress {
+		define("compress", "compress=true")
+	}
+	return labels, names
+}
+
+func headerLabels(option string, headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := []string{}
+	for _, name := range names {
+		// compose would interpolate $ in the value
+		labels = append(labels, fmt.Sprintf("headers.%s.%s=%s", option, name, strings.ReplaceAll(headers[name], "$", "$$")))
+	}
+	return labels
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mightymoud/sidekick/render"
+)
+
+const rollbackTimeout = 2 * time.Minute
+
+// CancelContext returns a context that is cancelled on the first interrupt
+// A second interrupt kills the process like it normally would
+func CancelContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// Orchestrator walks a command through its stages. When the context gets
+// cancelled it runs the registered rollback and reports the server state
+type Orchestrator struct {
+	ctx      context.Context
+	output   render.Output
+	rollback func(ctx context.Context) string
+	onExit   []func(ctx context.Context)
+	start    time.Time
+	outcome  string
+	message  string
+}
+
+func NewOrchestrator(ctx context.Context, output render.Output) *Orchestrator {
+	return &Orchestrator{ctx: ctx, output: output, start: time.Now()}
+}
+
+// OnCancel registers the compensating action for the stages run so far
+// It gets a fresh context and returns a report of what the server looks like after
+func (o *Orchestrator) OnCancel(rollback func(ctx context.Context) string) {
+	o.rollback = rollback
+}
+
+// OnExit registers a cleanup that runs before the final message is sent
+// whether the command succeeded, failed or got cancelled. Like defer, the
+// last registered cleanup runs first
+func (o *Orchestrator) OnExit(cleanup func(ctx context.Context)) {
+	o.onExit = append(o.onExit, cleanup)
+}
+
+// Lock takes the deploy lock of the app for the rest of the run
+// A dry run only checks that nobody else holds it
+func (o *Orchestrator) Lock(executor Executor, appName string, command string) error {
+	lock := NewDeployLock(command)
+	if err := AcquireLock(o.ctx, executor, appName, lock); err != nil {
+		return err
+	}
+	if executor.DryRun() {
+		return nil
+	}
+	o.OnExit(func(ctx context.Context) {
+		ReleaseLock(ctx, executor, appName, lock)
+	})
+	return nil
+}
+
+// Record appends entry to the history of the app once the command is over
+// with its outcome and duration filled in. Dry runs are not recorded
+func (o *Orchestrator) Record(executor Executor, appName string, entry *HistoryEntry) {
+	if executor.DryRun() {
+		return
+	}
+	o.OnExit(func(ctx context.Context) {
+		entry.Outcome = o.outcome
+		entry.Message = o.message
+		entry.DurationMs = time.Since(o.start).Milliseconds()
+		if entry.ImageDigest == "" && entry.Image != "" && o.outcome == OutcomeSuccess {
+			entry.ImageDigest = ImageDigest(ctx, executor, entry.Image)
+		}
+		AppendHistory(ctx, executor, appName, *entry)
+	})
+}
+
+// Done cleans up and tells the output the command finished successfully
+func (o *Orchestrator) Done(message string) {
+	o.outcome = OutcomeSuccess
+	o.exit()
+	o.output.Send(render.AllDoneMsg{Message: message})
+}
+
+// Failed reports whether the command has to stop after a stage
+// Errors are sent to the output, a cancelled run is rolled back first
+func (o *Orchestrator) Failed(err error, message string) bool {
+	if o.ctx.Err() != nil {
+		o.cancel()
+		return true
+	}
+	if err == nil {
+		return false
+	}
+	errStr := err.Error()
+	if message != "" {
+		errStr = fmt.Sprintf("%s: %s", message, err)
+	}
+	o.outcome, o.message = OutcomeFailed, errStr
+	o.exit()
+	o.output.Send(render.ErrorMsg{ErrorStr: errStr})
+	return true
+}
+
+func (o *Orchestrator) cancel() {
+	report := "Nothing was changed on your server."
+	if o.rollback != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+		report = o.rollback(ctx)
+	}
+	o.outcome, o.message = OutcomeCancelled, report
+	o.exit()
+	o.output.Send(render.CancelledMsg{Report: report})
+}
+
+func (o *Orchestrator) exit() {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	for i := len(o.onExit) - 1; i >= 0; i-- {
+		o.onExit[i](ctx)
+	}
+	o.onExit = nil
+}
+
+// RollbackService removes the containers of serviceName that a cancelled
+// run started, keeping the oldest `keep` running ones, and returns a report
+// of what is left on the server
+func RollbackService(ctx context.Context, executor Executor, dir string, serviceName string, keep int, cleanup ...string) string {
+	replacer := strings.NewReplacer(
+		"$service_dir", dir,
+		"$service_name", serviceName,
+		"$keep", fmt.Sprint(keep),
+		"$cleanup", strings.Join(append(cleanup, "true"), " && "),
+	)
+	output, err := executor.Run(ctx, replacer.Replace(RollbackServiceScript))
+	if err != nil {
+		return fmt.Sprintf("Rollback failed, please check your server manually: %s", err)
+	}
+	return strings.TrimSpace(output)
+}
+
+// RollbackPreview removes what a cancelled preview left on the server. The
+// cleanups run from the app dir so paths are relative to it
+func RollbackPreview(ctx context.Context, executor Executor, appName string, hash string, imgFileName string) string {
+	return RollbackService(ctx, executor, appName, fmt.Sprintf("%s-%s", appName, hash), 0,
+		fmt.Sprintf("rm -f %s", imgFileName),
+		fmt.Sprintf("rm -rf preview/%s", hash),
+	)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mightymoud/sidekick/render"
+)
+
+const maxShownFileSize = 1024 * 1024
+
+// Plan collects what a dry run of a command would have done on the VPS
+type Plan struct {
+	Command string          `json:"command"`
+	Actions []PlannedAction `json:"actions"`
+	mu      sync.Mutex
+}
+
+// NewPlan starts an empty plan, registered secrets are masked when it gets printed
+func NewPlan(command string) *Plan {
+	return &Plan{Command: command, Actions: []PlannedAction{}}
+}
+
+func (p *Plan) add(action PlannedAction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Actions = append(p.Actions, action)
+}
+
+func (p *Plan) mask(value string) string {
+	return render.Redact(value)
+}
+
+// DoneMessage is the final message of a command, it says nothing changed
+// for a dry run. A nil plan means the command really ran
+func (p *Plan) DoneMessage(message string) string {
+	if p == nil {
+		return message
+	}
+	return fmt.Sprintf("Dry run of %s finished - nothing was changed on your VPS", p.Command)
+}
+
+// Print writes the plan to w, as a single JSON object in json output mode.
+// Files are diffed against what was on the server when the plan was made
+func (p *Plan) Print(w io.Writer) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	type plannedFile struct {
+		Path string   `json:"path"`
+		Diff []string `json:"diff"`
+	}
+	files := []plannedFile{}
+	for _, action := range p.Actions {
+		if action.Path != "" {
+			files = append(files, plannedFile{Path: action.Path, Diff: LineDiff(p.mask(action.Current), p.mask(action.Content))})
+		}
+	}
+
+	if render.GetOutputMode() == render.OutputJSON {
+		actions := make([]PlannedAction, len(p.Actions))
+		for i, action := range p.Actions {
+			action.Command = p.mask(action.Command)
+			action.Content = p.mask(action.Content)
+			actions[i] = action
+		}
+		line, _ := json.Marshal(struct {
+			Event   string          `json:"event"`
+			Command string          `json:"command"`
+			Actions []PlannedAction `json:"actions"`
+			Files   []plannedFile   `json:"files"`
+		}{"plan", p.Command, actions, files})
+		fmt.Fprintln(w, string(line))
+		return
+	}
+
+	header := lipgloss.NewStyle().Foreground(lipgloss.Color("77")).MarginTop(1)
+	kind := lipgloss.NewStyle().Foreground(lipgloss.Color("99")).Width(8)
+	added := lipgloss.NewStyle().Foreground(lipgloss.Color("78"))
+	removed := lipgloss.NewStyle().Foreground(lipgloss.Color("204"))
+
+	fmt.Fprintln(w, header.Render(fmt.Sprintf("Plan for %s - nothing was changed on your VPS", p.Command)))
+	if len(p.Actions) == 0 {
+		fmt.Fprintln(w, "Nothing to do")
+	}
+	for i, action := range p.Actions {
+		command := p.mask(action.Command)
+		if action.User != "" && action.Kind == ActionRemote {
+			command = fmt.Sprintf("[%s] $ %s", action.User, command)
+		}
+		lines := strings.Split(strings.TrimSpace(command), "\n")
+		fmt.Fprintf(w, "%3d. %s %s\n", i+1, kind.Render(action.Kind), lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(w, "              %s\n", line)
+		}
+	}
+
+	for _, file := range files {
+		fmt.Fprintln(w, header.Render(fmt.Sprintf("Changes to %s:", file.Path)))
+		changed := false
+		for _, line := range file.Diff {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				changed = true
+				fmt.Fprintln(w, added.Render(line))
+			case strings.HasPrefix(line, "-"):
+				changed = true
+				fmt.Fprintln(w, removed.Render(line))
+			default:
+				fmt.Fprintln(w, line)
+			}
+		}
+		if !changed {
+			fmt.Fprintln(w, "No changes")
+		}
+	}
+}
+
+func isShownFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+func readSmallFile(name string) (string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxShownFileSize {
+		return "", fmt.Errorf("%s is too big to show", name)
+	}
+	content, err := os.ReadFile(name)
+	return string(content), err
+}
+
+// ReadRemoteFile returns the content of a file on the server, empty when it's missing
+func ReadRemoteFile(ctx context.Context, executor Executor, remotePath string) string {
+	if executor == nil {
+		return ""
+	}
+	content, err := executor.Query(ctx, fmt.Sprintf("[ -f %s ] && cat %s || true", remotePath, remotePath))
+	if err != nil {
+		return ""
+	}
+	return content
+}
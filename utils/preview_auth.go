@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PreviewAuthBasic = "basic"
+	PreviewAuthToken = "token"
+	PreviewAuthNone  = "none"
+	// PreviewPasswordKey keeps the preview secret in the encrypted env of the
+	// app. The _ keeps it out of the container
+	PreviewPasswordKey = "_PREVIEW_PASSWORD"
+	PreviewUser        = "preview"
+	PreviewTokenHeader = "X-Preview-Token"
+	// previewSecretPlaceholder stands in for the secret in a dry run, the
+	// real one is only generated when the plan is applied
+	previewSecretPlaceholder = "<generated when applied>"
+)
+
+// PreviewAuth is how the previews of an app are protected
+type PreviewAuth struct {
+	Mode   string
+	Secret string
+}
+
+// PreviewAuthMode is the auth of previews, basic unless sidekick.yml says otherwise
+func (c SidekickAppConfig) PreviewAuthMode() string {
+	if c.Preview.Auth == "" {
+		return PreviewAuthBasic
+	}
+	return c.Preview.Auth
+}
+
+// ValidatePreviewAuth checks the auth mode in sidekick.yml
+func ValidatePreviewAuth(mode string) error {
+	if mode != "" && !slices.Contains([]string{PreviewAuthBasic, PreviewAuthToken, PreviewAuthNone}, mode) {
+		return fmt.Errorf("preview auth must be basic, token or none, not %q", mode)
+	}
+	return nil
+}
+
+// EnsurePreviewAuth reads the preview secret of the app from its encrypted env,
+// generating it the first time. A dry run only records the write. The key is added to the managed keys of the
+// app so deploy keeps it
+func EnsurePreviewAuth(ctx context.Context, executor Executor, appConfig *SidekickAppConfig) (PreviewAuth, error) {
+	auth := PreviewAuth{Mode: appConfig.PreviewAuthMode()}
+	if auth.Mode == PreviewAuthNone {
+		return auth, nil
+	}
+	env, err := ReadRemoteEnv(ctx, executor, appConfig.Name)
+	if err != nil {
+		return auth, err
+	}
+	auth.Secret = env[PreviewPasswordKey]
+	if auth.Secret == "" {
+		auth.Secret = previewSecretPlaceholder
+		if !executor.DryRun() {
+			auth.Secret = generatePassword()
+		}
+		env[PreviewPasswordKey] = auth.Secret
+		if err := WriteRemoteEnv(ctx, executor, appConfig.Name, env); err != nil {
+			return auth, err
+		}
+	}
+	appConfig.Env.Manage(PreviewPasswordKey)
+	return auth, nil
+}
+
+// ReadPreviewAuth reads the preview secret without generating one
+func ReadPreviewAuth(ctx context.Context, executor Executor, appName string, mode string) (PreviewAuth, error) {
+	auth := PreviewAuth{Mode: mode}
+	if mode == PreviewAuthNone {
+		return auth, nil
+	}
+	env, err := ReadRemoteEnv(ctx, executor, appName)
+	if err != nil {
+		return auth, err
+	}
+	auth.Secret = env[PreviewPasswordKey]
+	return auth, nil
+}
+
+// String tells how to get past the auth of a preview
+func (a PreviewAuth) String() string {
+	switch a.Mode {
+	case PreviewAuthBasic:
+		return fmt.Sprintf("user %s, password %s", PreviewUser, a.Secret)
+	case PreviewAuthToken:
+		return fmt.Sprintf("header %s: %s", PreviewTokenHeader, a.Secret)
+	default:
+		return "public"
+	}
+}
+
+// applyPreviewAuth puts basic auth in front of the router of a preview, or
+// only routes requests that carry the token header
+func applyPreviewAuth(service *DockerService, router string, auth PreviewAuth) error {
+	switch auth.Mode {
+	case PreviewAuthBasic:
+		// the secret is long and random, so a low cost keeps requests fast without weakening it
+		hash, err := bcrypt.GenerateFromPassword([]byte(auth.Secret), bcrypt.MinCost)
+		if err != nil {
+			return err
+		}
+		middleware := router + "-previewauth"
+		service.Labels = append(service.Labels, fmt.Sprintf("traefik.http.middlewares.%s.basicauth.users=%s:%s", middleware, PreviewUser, strings.ReplaceAll(string(hash), "$", "$$")))
+		attachMiddleware(service, router, middleware)
+	case PreviewAuthToken:
+		rulePrefix := fmt.Sprintf("traefik.http.routers.%s.rule=", router)
+		for i, label := range service.Labels {
+			if strings.HasPrefix(label, rulePrefix) {
+				service.Labels[i] = fmt.Sprintf("%s && Header(`%s`, `%s`)", label, PreviewTokenHeader, auth.Secret)
+			}
+		}
+	}
+	return nil
+}
+
+// attachMiddleware runs middleware first on the router
+func attachMiddleware(service *DockerService, router string, middleware string) {
+	prefix := fmt.Sprintf("traefik.http.routers.%s.middlewares=", router)
+	for i, label := range service.Labels {
+		if strings.HasPrefix(label, prefix) {
+			service.Labels[i] = prefix + middleware + "," + strings.TrimPrefix(label, prefix)
+			return
+		}
+	}
+	service.Labels = append(service.Labels, prefix+middleware)
+}
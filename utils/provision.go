@@ -0,0 +1,206 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Provisioner sets a server up with the package manager of its distro
+type Provisioner struct {
+	// Distro is the ID from /etc/os-release
+	Distro string
+	// Arch is the Go name of the architecture of the server, amd64 or arm64
+	Arch           string
+	PackageManager string
+	// AdminGroup is the group of the users allowed to sudo
+	AdminGroup string
+	sshService string
+	// dockerRepo is the distro folder of download.docker.com
+	dockerRepo string
+	// epel is needed on the RHEL family for age
+	epel bool
+}
+
+// SopsVersion is installed on the server, its binary is checked against the
+// checksums published with the release
+const SopsVersion = "v3.9.0"
+
+// supportedArchs maps uname -m to the architectures sops and our images are built for
+var supportedArchs = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+}
+
+var aptDistros = []string{"ubuntu", "debian"}
+var dnfDistros = []string{"fedora", "rhel", "rocky", "almalinux", "centos"}
+
+// ProvisionerFor picks the provisioner of the distro described by osRelease,
+// the content of /etc/os-release, for the machine reported by uname -m
+func ProvisionerFor(osRelease string, machine string) (Provisioner, error) {
+	machine = strings.TrimSpace(machine)
+	arch, found := supportedArchs[machine]
+	if !found {
+		return Provisioner{}, fmt.Errorf("%s servers are not supported - sidekick needs a 64-bit x86 (amd64) or ARM (arm64) server", machine)
+	}
+	provisioner, err := distroProvisioner(osRelease)
+	provisioner.Arch = arch
+	return provisioner, err
+}
+
+func distroProvisioner(osRelease string) (Provisioner, error) {
+	release := map[string]string{}
+	for _, line := range strings.Split(osRelease, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if found {
+			release[key] = strings.Trim(value, `"'`)
+		}
+	}
+	distro := release["ID"]
+	switch {
+	case slices.Contains(aptDistros, distro):
+		return Provisioner{Distro: distro, PackageManager: "apt", AdminGroup: "sudo", sshService: "ssh", dockerRepo: distro}, nil
+	case distro == "fedora":
+		return Provisioner{Distro: distro, PackageManager: "dnf", AdminGroup: "wheel", sshService: "sshd", dockerRepo: "fedora"}, nil
+	case slices.Contains(dnfDistros, distro):
+		// Docker only publishes a rhel and a centos repo, the rebuilds use the centos one
+		dockerRepo := "centos"
+		if distro == "rhel" {
+			dockerRepo = "rhel"
+		}
+		return Provisioner{Distro: distro, PackageManager: "dnf", AdminGroup: "wheel", sshService: "sshd", dockerRepo: dockerRepo, epel: true}, nil
+	}
+	name := release["PRETTY_NAME"]
+	if name == "" {
+		name = "This distro"
+	}
+	return Provisioner{}, fmt.Errorf("%s is not supported yet - sidekick init works on Ubuntu, Debian, Fedora, RHEL, Rocky Linux and AlmaLinux", name)
+}
+
+// DetectProvisioner reads the distro and the architecture of the server
+func DetectProvisioner(ctx context.Context, executor Executor) (Provisioner, error) {
+	osRelease, err := executor.Query(ctx, "cat /etc/os-release")
+	if err != nil {
+		return Provisioner{}, fmt.Errorf("failed to detect the distro of your VPS: %w", err)
+	}
+	machine, err := executor.Query(ctx, "uname -m")
+	if err != nil {
+		return Provisioner{}, fmt.Errorf("failed to detect the architecture of your VPS: %w", err)
+	}
+	return ProvisionerFor(osRelease, machine)
+}
+
+// PlatformID is the docker platform images are built for
+func (p Provisioner) PlatformID() string {
+	return "linux/" + p.Arch
+}
+
+func (p Provisioner) UserSetupStage() CommandsStage {
+	return CommandsStage{
+		SpinnerSuccessMessage: "New user created successfully",
+		SpinnerFailMessage:    "Error creating a new user for the machine",
+		Commands: []string{
+			fmt.Sprintf("sudo useradd -m -s /bin/bash -G %s sidekick", p.AdminGroup),
+			`echo "sidekick ALL=(ALL) NOPASSWD: ALL" >> /etc/sudoers.d/sidekick`,
+			"mkdir -p /home/sidekick/.ssh/",
+			"sudo cat /root/.ssh/authorized_keys | sudo tee -a /home/sidekick/.ssh/authorized_keys",
+			"sudo chown sidekick:sidekick /home/sidekick/.ssh/authorized_keys",
+			"sudo chmod 600 /home/sidekick/.ssh/authorized_keys",
+		},
+	}
+}
+
+// SetupScript updates the server and installs what sidekick needs besides Docker
+func (p Provisioner) SetupScript() string {
+	packages := []string{"age", "ca-certificates", "curl", "vim"}
+	packageFunctions, enableRepos, afterInstall := aptFunctions, "", ""
+	if p.PackageManager == "dnf" {
+		// fuser for the rollback of init and cron for the backups aren't always there
+		packages = append(packages, "psmisc", "cronie")
+		packageFunctions = dnfFunctions
+		afterInstall = "sudo systemctl enable --now crond"
+	}
+	if p.epel {
+		enableRepos = "sudo dnf install -y https://dl.fedoraproject.org/pub/epel/epel-release-latest-$(rpm -E %rhel).noarch.rpm"
+	}
+	return strings.NewReplacer(
+		"$package_functions", packageFunctions,
+		"$ssh_service", p.sshService,
+		"$enable_repos", enableRepos,
+		"$packages", strings.Join(packages, " "),
+		"$after_install", afterInstall,
+		"$sops_version", SopsVersion,
+		"$arch", p.Arch,
+	).Replace(SetupStageScript)
+}
+
+// RollbackScript leaves the package manager in a usable state after a cancelled init
+func (p Provisioner) RollbackScript() string {
+	packageRecovery := aptRecovery
+	if p.PackageManager == "dnf" {
+		packageRecovery = dnfRecovery
+	}
+	return strings.ReplaceAll(RollbackInitScript, "$package_recovery", packageRecovery)
+}
+
+func (p Provisioner) SetupStage() CommandsStage {
+	return CommandsStage{
+		SpinnerSuccessMessage: "VPS updated and setup successfully",
+		SpinnerFailMessage:    "Error happened running basic setup commands",
+		Commands: []string{
+			fmt.Sprintf("echo '%s' > ./setup.sh", p.SetupScript()),
+			"chmod +x ./setup.sh",
+			"sudo ./setup.sh",
+			"rm ./setup.sh",
+		},
+	}
+}
+
+func (p Provisioner) DockerStage() CommandsStage {
+	stage := CommandsStage{
+		SpinnerSuccessMessage: "Docker setup successfully",
+		SpinnerFailMessage:    "Error happened during setting up docker",
+	}
+	packages := "docker-ce docker-ce-cli containerd.io docker-buildx-plugin docker-compose-plugin"
+	if p.PackageManager == "dnf" {
+		stage.Commands = []string{
+			// config-manager changed its flags with dnf5, the repo file is all it adds
+			fmt.Sprintf("sudo curl -fsSL https://download.docker.com/linux/%s/docker-ce.repo -o /etc/yum.repos.d/docker-ce.repo", p.dockerRepo),
+			fmt.Sprintf("sudo dnf install -y %s", packages),
+			"sudo systemctl enable --now docker",
+			"sudo usermod -aG docker sidekick",
+		}
+		return stage
+	}
+	stage.Commands = []string{
+		"sudo apt-get update -y",
+		"sudo install -m 0755 -d /etc/apt/keyrings",
+		fmt.Sprintf("sudo curl -fsSL https://download.docker.com/linux/%s/gpg -o /etc/apt/keyrings/docker.asc", p.dockerRepo),
+		"sudo chmod a+r /etc/apt/keyrings/docker.asc",
+		fmt.Sprintf(`echo \
+		"deb [arch=$(dpkg --print-architecture) signed-by=/etc/apt/keyrings/docker.asc] https://download.docker.com/linux/%s \
+		$(. /etc/os-release && echo "$VERSION_CODENAME") stable" | \
+		sudo tee /etc/apt/sources.list.d/docker.list > /dev/null`, p.dockerRepo),
+		"sudo apt-get update -y",
+		fmt.Sprintf("sudo apt-get install %s -y", packages),
+		"sudo usermod -aG docker sidekick",
+	}
+	return stage
+}
@@ -20,40 +20,31 @@ var sshKeyScript = `
 		echo "$publicKey" | ssh-keygen -lvf /dev/stdin 
 	`
 
-var EnvEncryptionScript = `
-	PUBKEY=$1
-	ENVFILE=$2
-
-	sops encrypt --output-type dotenv --age $PUBKEY $ENVFILE > encrypted.env
-	`
-
 var DeployAppWithEnvScript = `
-	export SOPS_AGE_KEY=$age_secret_key && \
 	cd $service_name && \
-	old_container_id=$(docker ps -f name=$service_name -q | tail -n1) && \
-	sops exec-env encrypted.env 'docker compose -p sidekick up -d --no-deps --scale $service_name=2 --no-recreate $service_name' && \
-	new_container_id=$(docker ps -f name=$service_name -q | head -n1) && \
+	old_container_id=$(docker ps -f label=com.docker.compose.service=$service_name -q | tail -n1) && \
+	sudo env SOPS_AGE_KEY_FILE=/etc/sidekick/age.key sops exec-env encrypted.env 'docker compose -p sidekick up -d --no-deps --scale $service_name=2 --no-recreate $service_name' && \
+	new_container_id=$(docker ps -f label=com.docker.compose.service=$service_name -q | head -n1) && \
 	new_container_ip=$(docker inspect -f '{{range.NetworkSettings.Networks}}{{.IPAddress}}{{end}}' $new_container_id) && \
 	curl --silent --include --retry-connrefused --retry 30 --retry-delay 1 --fail http://$new_container_ip:$app_port/up || exit 1 && \
 	docker stop $old_container_id && \
 	docker rm $old_container_id && \
-	sops exec-env encrypted.env 'docker compose -p sidekick up -d --scale $service_name=1 --no-recreate $service_name'
+	sudo env SOPS_AGE_KEY_FILE=/etc/sidekick/age.key sops exec-env encrypted.env 'docker compose -p sidekick up -d --scale $service_name=1 --no-recreate $service_name'
 	`
 
 var ForceDeployWithEnvScript = `
-	export SOPS_AGE_KEY=$age_secret_key && \
 	cd $service_name && \
 	old_container_id=$(docker ps -f label="traefik.enable=true" -q | tail -n1) && \
 	docker stop $old_container_id && \
 	docker rm $old_container_id && \
-	sops exec-env encrypted.env 'docker compose -p sidekick up -d'
+	sudo env SOPS_AGE_KEY_FILE=/etc/sidekick/age.key sops exec-env encrypted.env 'docker compose -p sidekick up -d'
 	`
 
 var DeployAppScript = `
 	cd $service_name && \
-	old_container_id=$(docker ps -f name=$service_name -q | tail -n1) && \
+	old_container_id=$(docker ps -f label=com.docker.compose.service=$service_name -q | tail -n1) && \
 	docker compose -p sidekick up -d --no-deps --scale $service_name=2 --no-recreate $service_name && \
-	new_container_id=$(docker ps -f name=$service_name -q | head -n1) && \
+	new_container_id=$(docker ps -f label=com.docker.compose.service=$service_name -q | head -n1) && \
 	new_container_ip=$(docker inspect -f '{{range.NetworkSettings.Networks}}{{.IPAddress}}{{end}}' $new_container_id) && \
 	curl --silent --include --retry-connrefused --retry 30 --retry-delay 1 --fail http://$new_container_ip:$app_port/up || exit 1 && \
 	docker stop $old_container_id && \
@@ -85,7 +76,7 @@ cd "$SERVICE"
 log "Starting rolling replace for service='$SERVICE', port=$APP_PORT"
 
 # find the old container (oldest for this service)
-old_container_id=$(docker ps -f "name=${SERVICE}" -q | tail -n1 || true)
+old_container_id=$(docker ps -f "label=com.docker.compose.service=${SERVICE}" -q | tail -n1 || true)
 if [[ -z "$old_container_id" ]]; then
   log "ERROR: no running containers found for service '${SERVICE}'."
   exit 3
@@ -103,7 +94,7 @@ if (( SLEEP_AFTER_START > 0 )); then
 fi
 
 # find newest container for this service
-new_container_id=$(docker ps -f "name=${SERVICE}" -q | head -n1 || true)
+new_container_id=$(docker ps -f "label=com.docker.compose.service=${SERVICE}" -q | head -n1 || true)
 if [[ -z "$new_container_id" ]]; then
   log "ERROR: failed to detect new container after scaling."
   exit 4
@@ -158,6 +149,38 @@ exit 0
 
 	`
 
+var RollbackServiceScript = `
+	cd $service_dir 2>/dev/null || { echo "Nothing was set up in $service_dir yet."; exit 0; }
+	$cleanup
+	filter="--filter label=com.docker.compose.project=sidekick --filter label=com.docker.compose.service=$service_name"
+	running=$(docker ps -q $filter)
+	running_count=$(echo "$running" | grep -c . || true)
+	if [ "$running_count" -gt $keep ]; then
+	  for id in $(echo "$running" | head -n $((running_count - $keep))); do
+	    docker rm -f "$id" > /dev/null && echo "Removed container $id started by the cancelled run"
+	  done
+	fi
+	for id in $(docker ps -aq --filter status=exited --filter status=created $filter); do
+	  docker rm "$id" > /dev/null && echo "Removed stopped container $id"
+	done
+	if [ -z "$(docker ps -q $filter)" ]; then
+	  echo "No container is running for $service_name"
+	else
+	  echo "Running containers for $service_name:"
+	  docker ps $filter --format "  {{.ID}}  {{.Image}}  {{.Status}}"
+	fi
+	`
+
+// RollbackInitScript is filled in by the provisioner of the distro with the
+// recovery of its package manager
+var RollbackInitScript = `
+	rm -f ./setup.sh
+	$package_recovery
+	command -v docker >/dev/null 2>&1 && echo "Docker: installed" || echo "Docker: not installed"
+	[ -d traefik ] && echo "Traefik: set up" || echo "Traefik: not set up"
+	echo "Every step of sidekick init is safe to repeat - run it again to finish setting up your server"
+	`
+
 var CheckGitTreeScript = `
 	if [[ -z $(git status -s) ]]
 	then
@@ -168,10 +191,36 @@ var CheckGitTreeScript = `
 	fi
 	`
 
+// SetupStageScript is filled in by the provisioner of the distro, it is echoed
+// into a file between single quotes so it can't use any
 var SetupStageScript = `
 #!/usr/bin/env bash
 set -e
+$package_functions
 
+echo "\033[0;32mUpdating SSH config...\033[0m"
+sudo sed -i "s/PermitRootLogin yes/PermitRootLogin no/" /etc/ssh/sshd_config
+sudo systemctl restart $ssh_service
+
+echo "\033[0;32mUpdating Packages...\033[0m"
+$enable_repos
+upgrade_packages
+
+echo "\033[0;32mInstalling Necessities ...\033[0m"
+install_packages $packages
+$after_install
+
+echo "\033[0;32mInstalling SOPS...\033[0m"
+sops_release=https://github.com/getsops/sops/releases/download/$sops_version
+sops_file=sops-$sops_version.linux.$arch
+cd "$(mktemp -d)"
+curl -fsSLO "$sops_release/$sops_file"
+curl -fsSLO "$sops_release/sops-$sops_version.checksums.txt"
+grep " ${sops_file}$" sops-$sops_version.checksums.txt | sha256sum -c -
+sudo install -m 0755 "$sops_file" /usr/local/bin/sops
+`
+
+var aptFunctions = `
 wait_for_locks() {
     echo "Waiting for apt/dpkg locks..."
     while fuser /var/lib/dpkg/lock >/dev/null 2>&1 \
@@ -180,56 +229,96 @@ wait_for_locks() {
         sleep 1
     done
 }
+upgrade_packages() {
+    wait_for_locks
+    sudo apt-get update -y
+    wait_for_locks
+    sudo apt-get upgrade -y
+}
+install_packages() {
+    wait_for_locks
+    sudo apt-get install -y "$@"
+}`
+
+// dnf waits for its own lock
+var dnfFunctions = `
+upgrade_packages() {
+    sudo dnf upgrade -y
+}
+install_packages() {
+    sudo dnf install -y "$@"
+}`
+
+// aptRecovery finishes configuring the packages an interrupted apt left behind
+var aptRecovery = `if sudo fuser /var/lib/dpkg/lock-frontend >/dev/null 2>&1; then
+	  echo "apt is still finishing up on your server"
+	elif [ -n "$(sudo dpkg --audit 2>/dev/null)" ]; then
+	  sudo dpkg --configure -a >/dev/null 2>&1 && echo "Finished configuring packages interrupted by the cancel"
+	fi`
+
+// rpm rolls an interrupted transaction back by itself, only report a dnf still running
+var dnfRecovery = `if sudo fuser "$(rpm --eval %_dbpath)/.rpm.lock" >/dev/null 2>&1; then
+	  echo "dnf is still finishing up on your server"
+	fi`
+
+// BackupScript dumps the database of an app, compresses it, encrypts it with
+// age and uploads it to the bucket, then prunes the oldest backups
+// It runs through sops exec-env so the credentials are in its environment. The
+// recipients are read on each run, from the key of the VPS and the env file of
+// the app, so sidekick keys doesn't have to rewrite it
+var BackupScript = `#!/bin/bash
+# written by sidekick db backup setup
+set -euo pipefail
 
-echo "\033[0;32mUpdating SSH config...\033[0m"
-sudo sed -i 's/PermitRootLogin yes/PermitRootLogin no/' /etc/ssh/sshd_config && sudo systemctl restart ssh
-sudo systemctl restart ssh
+id=$(date -u +%Y%m%dT%H%M%SZ)
+$s3_functions
 
-echo "\033[0;32mUpdating Packages...\033[0m"
-wait_for_locks
-sudo apt-get update -y
+dump() {
+	$dump_command
+}
 
-wait_for_locks
-sudo apt-get upgrade -y
+recipients=(-r "$(age-keygen -y /etc/sidekick/age.key)")
+for recipient in $(sed -n 's/^sops_age__list_[0-9]*__map_recipient=//p' encrypted.env); do
+	recipients+=(-r "$recipient")
+done
 
-echo "\033[0;32mInstalling Necessities ...\033[0m"
-wait_for_locks
-sudo apt-get install -y age ca-certificates curl vim
+dump | gzip | age "${recipients[@]}" | s3_in s3 cp - "s3://$bucket/$prefix/$id.$extension"
 
-echo "\033[0;32mInstalling SOPS...\033[0m"
-curl -sLO https://github.com/getsops/sops/releases/download/v3.9.0/sops-v3.9.0.linux.amd64
-sudo mv sops-v3.9.0.linux.amd64 /usr/local/bin/sops
-sudo chmod +x /usr/local/bin/sops
+s3 s3 ls "s3://$bucket/$prefix/" | awk '{print $4}' | grep '\.age$' | sort | head -n -$retention | while read -r old; do
+	s3 s3 rm "s3://$bucket/$prefix/$old" > /dev/null
+done
+echo "$id"
 `
 
-var TraefikDockerComposeFile = `
-services:
-  traefik-service:
-    image: traefik:v3.6.1
-    command:
-      - --api.insecure=false
-      - --entrypoints.web.address=:80
-      - --entrypoints.web.http.redirections.entryPoint.to=websecure
-      - --entrypoints.web.http.redirections.entryPoint.scheme=https
-      - --entrypoints.websecure.address=:443
-      - --entrypoints.websecure.http.tls.certresolver=default
-      - --providers.docker.exposedbydefault=false
-      - --certificatesresolvers.default.acme.email=$EMAIL
-      - --certificatesresolvers.default.acme.storage=/ssl-certs/acme.json
-      - --certificatesresolvers.default.acme.httpchallenge.entrypoint=web
-    ports:
-      - "80:80"
-      - "443:443"
-      # The Web UI (enabled by --api.insecure=true)
-      # - "8080:8080"
-    volumes:
-      # So that Traefik can listen to the Docker events
-      - /var/run/docker.sock:/var/run/docker.sock:ro
-      - ./traefik/ssl/:/ssl-certs/
-    networks:
-      - sidekick
-
-networks:
-  sidekick:
-    external: true
+// RestoreScript downloads a backup, decrypts it with the age key of the server,
+// or one it had before a rotate, and loads it into the running database
+var RestoreScript = `#!/bin/bash
+set -euo pipefail
+$s3_functions
+
+restore() {
+	$restore_command
+}
+
+identities=(-i /etc/sidekick/age.key)
+if [ -s /etc/sidekick/retired.keys ]; then
+	identities+=(-i /etc/sidekick/retired.keys)
+fi
+
+s3 s3 cp "s3://$bucket/$prefix/$id.$extension" - | age -d "${identities[@]}" | gunzip | restore
 `
+
+var ListBackupsScript = `#!/bin/bash
+set -euo pipefail
+$s3_functions
+
+s3 s3 ls "s3://$bucket/$prefix/" | grep '\.age$' || true
+`
+
+var backupS3Functions = `
+s3() {
+	docker run --rm --network sidekick -e AWS_ACCESS_KEY_ID="$BACKUP_S3_ACCESS_KEY_ID" -e AWS_SECRET_ACCESS_KEY="$BACKUP_S3_SECRET_ACCESS_KEY" -e AWS_DEFAULT_REGION="$region" amazon/aws-cli $endpoint "$@"
+}
+s3_in() {
+	docker run --rm -i --network sidekick -e AWS_ACCESS_KEY_ID="$BACKUP_S3_ACCESS_KEY_ID" -e AWS_SECRET_ACCESS_KEY="$BACKUP_S3_SECRET_ACCESS_KEY" -e AWS_DEFAULT_REGION="$region" amazon/aws-cli $endpoint "$@"
+}`
@@ -0,0 +1,206 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/viper"
+)
+
+// AgeKeyFile holds the age secret key on the server. It's owned by root and
+// only read through sudo so the key never shows up in a command line
+const AgeKeyFile = "/etc/sidekick/age.key"
+
+// RetiredAgeKeysFile keeps the keys the VPS had before a rotate, backups made
+// before it are still encrypted for them
+const RetiredAgeKeysFile = "/etc/sidekick/retired.keys"
+
+// generateAgeKeyCmd makes a new age identity in file on the server, so its
+// secret key never leaves the VPS
+func generateAgeKeyCmd(file string) string {
+	return fmt.Sprintf("sudo install -d -m 700 %s && sudo sh -c 'umask 077 && rm -f %s && age-keygen -o %s 2>/dev/null'", path.Dir(file), file, file)
+}
+
+// EnsureAgeKey gives the VPS an age key of its own when it has none. Servers
+// set up by older versions used the key of this machine, their env files are
+// re-encrypted for the new key
+func EnsureAgeKey(ctx context.Context, executor Executor) error {
+	output, err := executor.Query(ctx, fmt.Sprintf(`sudo test -s %s && echo "1" || echo "0"`, AgeKeyFile))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(output) == "1" {
+		return nil
+	}
+	if _, err := executor.Run(ctx, generateAgeKeyCmd(AgeKeyFile)); err != nil {
+		return fmt.Errorf("failed to generate the age key of your VPS: %w", err)
+	}
+	serverPublicKey, err := ServerPublicKey(ctx, executor)
+	if err != nil {
+		return err
+	}
+	viper.Set("serverPublicKey", serverPublicKey)
+
+	files, err := ListRemoteEnvFiles(ctx, executor)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		encrypted := ReadRemoteFile(ctx, executor, file)
+		env, err := DecryptEnv([]byte(encrypted))
+		if err != nil {
+			return fmt.Errorf("%s: %w - is this machine one of its recipients?", file, err)
+		}
+		reencrypted, err := EncryptEnv(env, AgeRecipients(FileRecipients([]byte(encrypted))...))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := executor.WriteFile(ctx, file, reencrypted); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return executor.Local("save the public key of your VPS to your sidekick config", viper.WriteConfig)
+}
+
+// SopsExecEnv wraps command so it runs with the secrets of envFile in its environment
+func SopsExecEnv(envFile string, command string) string {
+	return fmt.Sprintf("sudo env SOPS_AGE_KEY_FILE=%s sops exec-env %s '%s'", AgeKeyFile, envFile, command)
+}
+
+// DecryptEnv decrypts a sops dotenv file in memory with the age secret key
+func DecryptEnv(encrypted []byte) (map[string]string, error) {
+	if len(bytes.TrimSpace(encrypted)) == 0 {
+		return map[string]string{}, nil
+	}
+	env, err := sopsDecryptDotenv(encrypted, viper.GetString("secretKey"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt env file: %w", err)
+	}
+	return env, nil
+}
+
+// EncryptEnv encrypts env for the age recipients without writing it to disk
+func EncryptEnv(env map[string]string, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients to encrypt for - run sidekick init")
+	}
+	encrypted, err := sopsEncryptDotenv(env, recipients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt env file: %w", err)
+	}
+	return encrypted, nil
+}
+
+// EnvHash is the checksum we keep in sidekick.yml to tell if env values changed
+func EnvHash(env map[string]string) string {
+	content, _ := godotenv.Marshal(env)
+	return fmt.Sprintf("%x", md5.Sum([]byte(content)))
+}
+
+// ReadRemoteEnv decrypts the encrypted.env of an app folder on the server
+func ReadRemoteEnv(ctx context.Context, executor Executor, dir string) (map[string]string, error) {
+	encrypted, err := executor.Query(ctx, fmt.Sprintf("[ -f %s/encrypted.env ] && cat %s/encrypted.env || true", dir, dir))
+	if err != nil {
+		return nil, err
+	}
+	return DecryptEnv([]byte(encrypted))
+}
+
+// WriteRemoteEnv encrypts env into the encrypted.env of dir. Recipients of the
+// current file are kept so a teammate missing from our config doesn't lose access
+func WriteRemoteEnv(ctx context.Context, executor Executor, dir string, env map[string]string) error {
+	current := ReadRemoteFile(ctx, executor, fmt.Sprintf("%s/encrypted.env", dir))
+	encrypted, err := EncryptEnv(env, AgeRecipients(FileRecipients([]byte(current))...))
+	if err != nil {
+		return err
+	}
+	return executor.WriteFile(ctx, fmt.Sprintf("%s/encrypted.env", dir), encrypted)
+}
+
+// RestartWithEnv replaces the running container of the app with one that
+// gets the current encrypted.env, with the same zero downtime swap as deploy
+func RestartWithEnv(ctx context.Context, executor Executor, appConfig SidekickAppConfig) (string, error) {
+	if err := EnsureAgeKey(ctx, executor); err != nil {
+		return "", err
+	}
+	replacer := strings.NewReplacer(
+		"$service_name", appConfig.Name,
+		"$app_port", fmt.Sprint(appConfig.Port),
+	)
+	return executor.Run(ctx, replacer.Replace(DeployAppWithEnvScript))
+}
+
+// EnvChanges lists the keys that differ between two versions of an env
+type EnvChanges struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// DiffEnv compares the keys and values of before and after
+func DiffEnv(before map[string]string, after map[string]string) EnvChanges {
+	changes := EnvChanges{}
+	for _, key := range EnvKeys(after) {
+		value, ok := before[key]
+		if !ok {
+			changes.Added = append(changes.Added, key)
+		} else if value != after[key] {
+			changes.Changed = append(changes.Changed, key)
+		}
+	}
+	for _, key := range EnvKeys(before) {
+		if _, ok := after[key]; !ok {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+	return changes
+}
+
+func (c EnvChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// Lines describes the changes one key per line, without the values
+func (c EnvChanges) Lines() []string {
+	lines := []string{}
+	for _, key := range c.Added {
+		lines = append(lines, "+ "+key)
+	}
+	for _, key := range c.Removed {
+		lines = append(lines, "- "+key)
+	}
+	for _, key := range c.Changed {
+		lines = append(lines, "~ "+key)
+	}
+	return lines
+}
+
+// EnvKeys returns the keys of env sorted
+func EnvKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
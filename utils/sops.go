@@ -0,0 +1,303 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// The files we write are read on the server by sops exec-env, so they follow
+// the dotenv format of sops: every value is encrypted with AES256_GCM under a
+// data key, the data key is encrypted with age for each recipient and a MAC
+// over all the values protects the file from tampering
+const (
+	sopsVersion           = "3.9.0"
+	sopsUnencryptedSuffix = "_unencrypted"
+	sopsMetadataPrefix    = "sops_"
+	sopsNonceSize         = 32
+)
+
+var (
+	sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+	sopsAgePattern   = regexp.MustCompile(`^sops_age__list_(\d+)__map_(enc|recipient)$`)
+)
+
+type sopsLine struct {
+	key   string
+	value string
+}
+
+// sopsEncryptDotenv encrypts env, sorted by key, for every age recipient
+func sopsEncryptDotenv(env map[string]string, recipients []string) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	hash := sha512.New()
+	for _, key := range EnvKeys(env) {
+		value := env[key]
+		hash.Write([]byte(value))
+		if strings.HasSuffix(key, sopsUnencryptedSuffix) {
+			// written as is, a newline can't be told apart from an escaped one
+			if strings.Contains(value, "\n") {
+				return nil, fmt.Errorf("%s can't span several lines", key)
+			}
+		} else {
+			encrypted, err := sopsEncryptValue(value, dataKey, key+":")
+			if err != nil {
+				return nil, err
+			}
+			value = encrypted
+		}
+		fmt.Fprintf(&out, "%s=%s\n", key, value)
+	}
+
+	metadata := map[string]string{}
+	for i, recipient := range recipients {
+		enc, err := ageEncryptDataKey(dataKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		metadata[fmt.Sprintf("sops_age__list_%d__map_enc", i)] = enc
+		metadata[fmt.Sprintf("sops_age__list_%d__map_recipient", i)] = recipient
+	}
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	mac, err := sopsEncryptValue(fmt.Sprintf("%X", hash.Sum(nil)), dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	metadata["sops_lastmodified"] = lastModified
+	metadata["sops_mac"] = mac
+	metadata["sops_unencrypted_suffix"] = sopsUnencryptedSuffix
+	metadata["sops_version"] = sopsVersion
+
+	metadataKeys := EnvKeys(metadata)
+	for _, key := range metadataKeys {
+		fmt.Fprintf(&out, "%s=%s\n", key, sopsEscape(metadata[key]))
+	}
+	return out.Bytes(), nil
+}
+
+// sopsDecryptDotenv decrypts a sops dotenv file with the age identity in secretKey
+func sopsDecryptDotenv(encrypted []byte, secretKey string) (map[string]string, error) {
+	lines, err := sopsParseDotenv(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	values := []sopsLine{}
+	for _, line := range lines {
+		if strings.HasPrefix(line.key, sopsMetadataPrefix) {
+			metadata[line.key] = sopsUnescape(line.value)
+		} else {
+			values = append(values, line)
+		}
+	}
+	if metadata["sops_mac"] == "" {
+		return nil, fmt.Errorf("not a sops encrypted file")
+	}
+
+	dataKey, err := sopsDataKey(metadata, secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := metadata["sops_unencrypted_suffix"]
+	env := map[string]string{}
+	hash := sha512.New()
+	for _, line := range values {
+		value := line.value
+		if suffix == "" || !strings.HasSuffix(line.key, suffix) {
+			if value, err = sopsDecryptValue(sopsUnescape(value), dataKey, line.key+":"); err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s: %w", line.key, err)
+			}
+		}
+		hash.Write([]byte(value))
+		env[line.key] = value
+	}
+
+	mac, err := sopsDecryptValue(metadata["sops_mac"], dataKey, metadata["sops_lastmodified"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify the MAC: %w", err)
+	}
+	if mac != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return nil, fmt.Errorf("MAC mismatch, the file was tampered with")
+	}
+	return env, nil
+}
+
+// sopsDataKey finds the age recipient entry secretKey can open
+func sopsDataKey(metadata map[string]string, secretKey string) ([]byte, error) {
+	identity, err := age.ParseX25519Identity(strings.TrimSpace(secretKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age secret key: %w", err)
+	}
+	entries := map[int]string{}
+	for key, value := range metadata {
+		match := sopsAgePattern.FindStringSubmatch(key)
+		if match == nil || match[2] != "enc" {
+			continue
+		}
+		i, _ := strconv.Atoi(match[1])
+		entries[i] = value
+	}
+	indexes := make([]int, 0, len(entries))
+	for i := range entries {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		reader, err := age.Decrypt(armor.NewReader(strings.NewReader(entries[i])), identity)
+		if err != nil {
+			continue
+		}
+		dataKey, err := io.ReadAll(reader)
+		if err == nil && len(dataKey) == 32 {
+			return dataKey, nil
+		}
+	}
+	return nil, fmt.Errorf("the key of this machine is not one of the recipients")
+}
+
+func ageEncryptDataKey(dataKey []byte, recipient string) (string, error) {
+	ageRecipient, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid age recipient %s: %w", recipient, err)
+	}
+	var out bytes.Buffer
+	armorWriter := armor.NewWriter(&out)
+	writer, err := age.Encrypt(armorWriter, ageRecipient)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func sopsEncryptValue(plaintext string, dataKey []byte, additionalData string) (string, error) {
+	// sops leaves empty values empty
+	if plaintext == "" {
+		return "", nil
+	}
+	gcm, err := sopsCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, sopsNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+	), nil
+}
+
+func sopsDecryptValue(value string, dataKey []byte, additionalData string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	match := sopsValuePattern.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("value is not encrypted with AES256_GCM")
+	}
+	decoded := [3][]byte{}
+	for i := range decoded {
+		part, err := base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			return "", err
+		}
+		decoded[i] = part
+	}
+	data, iv, tag := decoded[0], decoded[1], decoded[2]
+	gcm, err := sopsCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != sopsNonceSize {
+		return "", fmt.Errorf("unexpected iv size %d", len(iv))
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func sopsCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+}
+
+// sopsParseDotenv reads KEY=value lines the way the dotenv store of sops does
+func sopsParseDotenv(content []byte) ([]sopsLine, error) {
+	lines := []sopsLine{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid dotenv line: %s", key)
+		}
+		// values are kept as written, only encrypted ones and metadata get unescaped
+		lines = append(lines, sopsLine{key: key, value: value})
+	}
+	return lines, scanner.Err()
+}
+
+func sopsEscape(value string) string {
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func sopsUnescape(value string) string {
+	return strings.ReplaceAll(value, `\n`, "\n")
+}
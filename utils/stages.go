@@ -15,62 +15,24 @@ limitations under the License.
 package utils
 
 import (
+	"encoding/base64"
 	"fmt"
-	"strings"
-)
-
-var UsersetupStage = CommandsStage{
-	SpinnerSuccessMessage: "New user created successfully",
-	SpinnerFailMessage:    "Error creating a new user for the machine",
-	Commands: []string{
-		"sudo useradd -m -s /bin/bash -G sudo sidekick",
-		`echo "sidekick ALL=(ALL) NOPASSWD: ALL" >> /etc/sudoers.d/sidekick`,
-		"mkdir -p /home/sidekick/.ssh/",
-		"sudo cat /root/.ssh/authorized_keys | sudo tee -a /home/sidekick/.ssh/authorized_keys",
-		"sudo chown sidekick:sidekick /home/sidekick/.ssh/authorized_keys",
-		"sudo chmod 600 /home/sidekick/.ssh/authorized_keys",
-	},
-}
 
-var SetupStage = CommandsStage{
-	SpinnerSuccessMessage: "VPS updated and setup successfully",
-	SpinnerFailMessage:    "Error happened running basic setup commands",
-	Commands: []string{
-		fmt.Sprintf("echo '%s' > ./setup.sh", SetupStageScript),
-		"chmod +x ./setup.sh",
-		"sudo ./setup.sh",
-		"rm ./setup.sh",
-	},
-}
-
-var DockerStage = CommandsStage{
-	SpinnerSuccessMessage: "Docker setup successfully",
-	SpinnerFailMessage:    "Error happened during setting up docker",
-	Commands: []string{
-		"sudo apt-get update -y",
-		"sudo install -m 0755 -d /etc/apt/keyrings",
-		"sudo curl -fsSL https://download.docker.com/linux/ubuntu/gpg -o /etc/apt/keyrings/docker.asc",
-		"sudo chmod a+r /etc/apt/keyrings/docker.asc",
-		`echo \
-		"deb [arch=$(dpkg --print-architecture) signed-by=/etc/apt/keyrings/docker.asc] https://download.docker.com/linux/ubuntu \
-		$(. /etc/os-release && echo "$VERSION_CODENAME") stable" | \
-		sudo tee /etc/apt/sources.list.d/docker.list > /dev/null`,
-		"sudo apt-get update -y",
-		"sudo apt-get install docker-ce docker-ce-cli containerd.io docker-buildx-plugin docker-compose-plugin -y",
-		"sudo usermod -aG docker sidekick",
-	},
-}
+	"gopkg.in/yaml.v3"
+)
 
 func GetTraefikStage(email string) CommandsStage {
+	composeFile, _ := yaml.Marshal(TraefikComposeFile(TraefikConfig{Email: email}))
 	return CommandsStage{
 		SpinnerSuccessMessage: "Successfully setup Traefik",
 		SpinnerFailMessage:    "Something went wrong setting up Traefik on your VPS",
 		Commands: []string{
 			"mkdir traefik",
-			fmt.Sprintf("echo '%s' > ./traefik/docker-compose.yml", strings.Replace(TraefikDockerComposeFile, "$EMAIL", email, 1)),
+			fmt.Sprintf("echo '%s' | base64 -d > ./traefik/docker-compose.yml", base64.StdEncoding.EncodeToString(composeFile)),
 			"mkdir -p ./traefik/ssl-certs/",
 			"touch ./traefik/ssl-certs/acme.json",
 			"chmod 600 ./traefik/ssl-certs/acme.json",
+			"mkdir -p ./traefik/certs ./traefik/dynamic",
 			"sudo docker network create sidekick",
 			"cd traefik && sudo docker compose -p sidekick up -d",
 		},
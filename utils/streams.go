@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+var streamNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// reservedPorts are taken by the web entrypoints and the dashboard of Traefik
+var reservedPorts = []uint64{80, 443, TraefikDashboardPort}
+
+// ValidateStreams checks the TCP and UDP services of the app
+func ValidateStreams(appConfig SidekickAppConfig) error {
+	names := []string{}
+	for protocol, streams := range map[string][]SidekickAppStreamConfig{"tcp": appConfig.TCP, "udp": appConfig.UDP} {
+		for _, stream := range streams {
+			if !streamNamePattern.MatchString(stream.Name) {
+				return fmt.Errorf("%s service name %q must be lowercase letters, digits and dashes", protocol, stream.Name)
+			}
+			if slices.Contains(names, stream.Name) {
+				return fmt.Errorf("%s is used by two tcp or udp services", stream.Name)
+			}
+			names = append(names, stream.Name)
+			if stream.Port == 0 || stream.Port > 65535 || stream.ListenPort > 65535 {
+				return fmt.Errorf("%s service %s needs a valid port", protocol, stream.Name)
+			}
+			if slices.Contains(reservedPorts, stream.ListenPort) {
+				return fmt.Errorf("%s service %s can't listen on %d, Traefik uses it", protocol, stream.Name, stream.ListenPort)
+			}
+			if protocol == "udp" && stream.ListenPort == 0 {
+				return fmt.Errorf("udp service %s needs a listenPort", stream.Name)
+			}
+			if protocol == "udp" && stream.SNI != "" {
+				return fmt.Errorf("udp service %s can't route by sni", stream.Name)
+			}
+			if protocol == "tcp" && stream.ListenPort == 0 && stream.SNI == "" {
+				return fmt.Errorf("tcp service %s needs a listenPort or an sni to route on 443", stream.Name)
+			}
+			if stream.SNI != "" {
+				if err := ValidateDomain(SidekickAppDomainConfig{Host: stream.SNI}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// appEntryPoints are the Traefik entrypoints the TCP and UDP services of the app listen on
+func appEntryPoints(appConfig SidekickAppConfig) []TraefikEntryPoint {
+	entryPoints := []TraefikEntryPoint{}
+	for _, stream := range appConfig.TCP {
+		if stream.ListenPort != 0 {
+			entryPoints = append(entryPoints, TraefikEntryPoint{Port: stream.ListenPort, Protocol: "tcp"})
+		}
+	}
+	for _, stream := range appConfig.UDP {
+		entryPoints = append(entryPoints, TraefikEntryPoint{Port: stream.ListenPort, Protocol: "udp"})
+	}
+	return entryPoints
+}
+
+// streamLabels are the Traefik TCP and UDP router labels of the app
+func streamLabels(appConfig SidekickAppConfig) []string {
+	labels := []string{}
+	for _, stream := range appConfig.TCP {
+		router := fmt.Sprintf("%s-%s", appConfig.Name, stream.Name)
+		entryPoint := "websecure"
+		if stream.ListenPort != 0 {
+			entryPoint = TraefikEntryPoint{Port: stream.ListenPort, Protocol: "tcp"}.Name()
+		}
+		labels = append(labels,
+			fmt.Sprintf("traefik.tcp.routers.%s.entrypoints=%s", router, entryPoint),
+			fmt.Sprintf("traefik.tcp.routers.%s.service=%s", router, router),
+			fmt.Sprintf("traefik.tcp.services.%s.loadbalancer.server.port=%d", router, stream.Port),
+		)
+		if stream.SNI == "" {
+			// plain TCP, everything on the entrypoint goes to the app
+			labels = append(labels, fmt.Sprintf("traefik.tcp.routers.%s.rule=HostSNI(`*`)", router))
+			continue
+		}
+		labels = append(labels,
+			fmt.Sprintf("traefik.tcp.routers.%s.rule=HostSNI(`%s`)", router, stream.SNI),
+			fmt.Sprintf("traefik.tcp.routers.%s.tls=true", router),
+			fmt.Sprintf("traefik.tcp.routers.%s.tls.certresolver=%s", router, CertResolverHTTP),
+		)
+	}
+	for _, stream := range appConfig.UDP {
+		router := fmt.Sprintf("%s-%s", appConfig.Name, stream.Name)
+		labels = append(labels,
+			fmt.Sprintf("traefik.udp.routers.%s.entrypoints=%s", router, TraefikEntryPoint{Port: stream.ListenPort, Protocol: "udp"}.Name()),
+			fmt.Sprintf("traefik.udp.routers.%s.service=%s", router, router),
+			fmt.Sprintf("traefik.udp.services.%s.loadbalancer.server.port=%d", router, stream.Port),
+		)
+	}
+	return labels
+}
@@ -0,0 +1,281 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CertResolverHTTP gets certificates with the HTTP challenge, one per domain
+	CertResolverHTTP = "default"
+	// CertResolverDNS gets certificates with the DNS challenge, which wildcards need
+	CertResolverDNS = "dns"
+	// TraefikDir is where init sets up Traefik on the server
+	TraefikDir = "traefik"
+	// TraefikService is the compose service of Traefik in the sidekick project
+	TraefikService        = "traefik-service"
+	DefaultTraefikVersion = "v3.6.1"
+	// TraefikDashboardPort only listens on the loopback of the server, reach it over SSH
+	TraefikDashboardPort = 8080
+)
+
+// TraefikConfig is how Traefik runs on the server, kept under traefik in the sidekick config
+type TraefikConfig struct {
+	Version      string              `yaml:"version,omitempty" mapstructure:"version"`
+	AccessLogs   bool                `yaml:"accessLogs,omitempty" mapstructure:"accessLogs"`
+	Dashboard    bool                `yaml:"dashboard,omitempty" mapstructure:"dashboard"`
+	DNSChallenge TraefikDNSChallenge `yaml:"dnsChallenge,omitempty" mapstructure:"dnsChallenge"`
+	// EntryPoints are the extra ports apps route TCP and UDP through. They are
+	// read from the apps on the server so they're never saved here
+	EntryPoints []TraefikEntryPoint `yaml:"-" mapstructure:"-"`
+	// Email is the certEmail of the sidekick config
+	Email string `yaml:"-" mapstructure:"-"`
+}
+
+// TraefikEntryPoint is a port of the server Traefik listens on
+type TraefikEntryPoint struct {
+	Port     uint64 `yaml:"port" mapstructure:"port"`
+	Protocol string `yaml:"protocol" mapstructure:"protocol"`
+}
+
+// Name is how routers refer to the entrypoint, like tcp-1883
+func (e TraefikEntryPoint) Name() string {
+	return fmt.Sprintf("%s-%d", e.Protocol, e.Port)
+}
+
+var (
+	entryPointLabelPattern   = regexp.MustCompile(`^traefik\.(?:tcp|udp)\.routers\.[^=]+\.entrypoints=(tcp|udp)-(\d+)$`)
+	entryPointCommandPattern = regexp.MustCompile(`^--entrypoints\.(tcp|udp)-(\d+)\.address=`)
+)
+
+func parseEntryPoint(pattern *regexp.Regexp, value string) (TraefikEntryPoint, bool) {
+	match := pattern.FindStringSubmatch(value)
+	if match == nil {
+		return TraefikEntryPoint{}, false
+	}
+	port, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil {
+		return TraefikEntryPoint{}, false
+	}
+	return TraefikEntryPoint{Port: port, Protocol: match[1]}, true
+}
+
+// ServerEntryPoints are the entrypoints the TCP and UDP routers of the
+// containers on the server use, stopped ones included
+func ServerEntryPoints(ctx context.Context, executor Executor) ([]TraefikEntryPoint, error) {
+	output, err := executor.Query(ctx, `docker ps -a --format '{{.Labels}}'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the entrypoints of your apps: %w", err)
+	}
+	entryPoints := []TraefikEntryPoint{}
+	for _, label := range strings.FieldsFunc(output, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if entryPoint, ok := parseEntryPoint(entryPointLabelPattern, strings.TrimSpace(label)); ok && !slices.Contains(entryPoints, entryPoint) {
+			entryPoints = append(entryPoints, entryPoint)
+		}
+	}
+	return entryPoints, nil
+}
+
+// runningEntryPoints are the entrypoints in the compose file Traefik runs with
+func runningEntryPoints(ctx context.Context, executor Executor) []TraefikEntryPoint {
+	composeFile := DockerComposeFile{}
+	yaml.Unmarshal([]byte(ReadRemoteFile(ctx, executor, fmt.Sprintf("%s/docker-compose.yml", TraefikDir))), &composeFile)
+	entryPoints := []TraefikEntryPoint{}
+	for _, command := range composeFile.Services[TraefikService].Command {
+		if entryPoint, ok := parseEntryPoint(entryPointCommandPattern, command); ok {
+			entryPoints = append(entryPoints, entryPoint)
+		}
+	}
+	return entryPoints
+}
+
+// TraefikDNSChallenge configures the DNS challenge resolver of Traefik. The
+// credentials of the provider live in the encrypted env of TraefikDir and only
+// their keys are kept here
+type TraefikDNSChallenge struct {
+	Provider  string   `yaml:"provider" mapstructure:"provider"`
+	Keys      []string `yaml:"keys,omitempty" mapstructure:"keys"`
+	Resolvers []string `yaml:"resolvers,omitempty" mapstructure:"resolvers"`
+	// CAServer replaces Let's Encrypt, like a Pebble server for testing
+	CAServer string `yaml:"caServer,omitempty" mapstructure:"caServer"`
+}
+
+// LoadTraefikConfig reads the Traefik config from the sidekick config
+func LoadTraefikConfig() TraefikConfig {
+	config := TraefikConfig{}
+	viper.UnmarshalKey("traefik", &config)
+	if config.Version == "" {
+		config.Version = DefaultTraefikVersion
+	}
+	config.Email = viper.GetString("certEmail")
+	return config
+}
+
+// SaveTraefikConfig writes the Traefik config to the sidekick config
+func SaveTraefikConfig(config TraefikConfig) error {
+	viper.Set("certEmail", config.Email)
+	viper.Set("traefik", config)
+	return viper.WriteConfig()
+}
+
+// TraefikComposeFile is the compose file of Traefik for config
+func TraefikComposeFile(config TraefikConfig) DockerComposeFile {
+	version := config.Version
+	if version == "" {
+		version = DefaultTraefikVersion
+	}
+	commands := []string{
+		"--entrypoints.web.address=:80",
+		"--entrypoints.web.http.redirections.entryPoint.to=websecure",
+		"--entrypoints.web.http.redirections.entryPoint.scheme=https",
+		"--entrypoints.websecure.address=:443",
+		fmt.Sprintf("--entrypoints.websecure.http.tls.certresolver=%s", CertResolverHTTP),
+		"--providers.docker.exposedbydefault=false",
+		// certificates brought with sidekick certs add
+		"--providers.file.directory=/dynamic",
+		"--providers.file.watch=true",
+		fmt.Sprintf("--certificatesresolvers.%s.acme.email=%s", CertResolverHTTP, config.Email),
+		fmt.Sprintf("--certificatesresolvers.%s.acme.storage=/ssl-certs/acme.json", CertResolverHTTP),
+		fmt.Sprintf("--certificatesresolvers.%s.acme.httpchallenge.entrypoint=web", CertResolverHTTP),
+	}
+	ports := []string{"80:80", "443:443"}
+	environment := []string{}
+
+	dns := config.DNSChallenge
+	if dns.Provider != "" {
+		commands = append(commands,
+			fmt.Sprintf("--certificatesresolvers.%s.acme.email=%s", CertResolverDNS, config.Email),
+			fmt.Sprintf("--certificatesresolvers.%s.acme.storage=/ssl-certs/acme.json", CertResolverDNS),
+			fmt.Sprintf("--certificatesresolvers.%s.acme.dnschallenge.provider=%s", CertResolverDNS, dns.Provider),
+		)
+		if len(dns.Resolvers) > 0 {
+			commands = append(commands, fmt.Sprintf("--certificatesresolvers.%s.acme.dnschallenge.resolvers=%s", CertResolverDNS, strings.Join(dns.Resolvers, ",")))
+		}
+		if dns.CAServer != "" {
+			commands = append(commands,
+				fmt.Sprintf("--certificatesresolvers.%s.acme.caserver=%s", CertResolverHTTP, dns.CAServer),
+				fmt.Sprintf("--certificatesresolvers.%s.acme.caserver=%s", CertResolverDNS, dns.CAServer),
+			)
+		}
+		for _, key := range dns.Keys {
+			environment = append(environment, fmt.Sprintf("%s=${%s}", key, key))
+		}
+	}
+
+	for _, entryPoint := range config.EntryPoints {
+		address := fmt.Sprintf(":%d", entryPoint.Port)
+		port := fmt.Sprintf("%d:%d", entryPoint.Port, entryPoint.Port)
+		if entryPoint.Protocol == "udp" {
+			address += "/udp"
+			port += "/udp"
+		}
+		commands = append(commands, fmt.Sprintf("--entrypoints.%s.address=%s", entryPoint.Name(), address))
+		ports = append(ports, port)
+	}
+
+	if config.AccessLogs {
+		commands = append(commands, "--accesslog=true")
+	}
+	if config.Dashboard {
+		// insecure only means the api is served on its own entrypoint, which we
+		// publish on the loopback of the server
+		commands = append(commands, "--api.dashboard=true", "--api.insecure=true")
+		ports = append(ports, fmt.Sprintf("127.0.0.1:%d:8080", TraefikDashboardPort))
+	} else {
+		commands = append(commands, "--api.insecure=false")
+	}
+
+	return composeFile(TraefikService, DockerService{
+		Image:   fmt.Sprintf("traefik:%s", version),
+		Command: commands,
+		Ports:   ports,
+		Volumes: []string{
+			// so that Traefik can listen to the Docker events
+			"/var/run/docker.sock:/var/run/docker.sock:ro",
+			"./traefik/ssl/:/ssl-certs/",
+			"./certs/:/certs/:ro",
+			"./dynamic/:/dynamic/:ro",
+		},
+		Environment: environment,
+		Networks:    []string{"sidekick"},
+	})
+}
+
+// EnsureTraefikEntryPoints adds the entrypoints the TCP and UDP services of the
+// app need to Traefik, restarting it if any are new. Entrypoints are shared
+// between apps so they are never removed here
+func EnsureTraefikEntryPoints(ctx context.Context, executor Executor, appConfig SidekickAppConfig) error {
+	running := runningEntryPoints(ctx, executor)
+	config := LoadTraefikConfig()
+	added := false
+	for _, entryPoint := range appEntryPoints(appConfig) {
+		config.EntryPoints = append(config.EntryPoints, entryPoint)
+		if !slices.Contains(running, entryPoint) {
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+	return WithLock(ctx, executor, TraefikDir, "add Traefik entrypoints", func() error {
+		return RestartTraefik(ctx, executor, config)
+	})
+}
+
+// RestartTraefik writes the compose file of Traefik and recreates it, with the
+// credentials of the DNS provider in its environment
+func RestartTraefik(ctx context.Context, executor Executor, config TraefikConfig) error {
+	// the entrypoints of every app, whoever deployed it, so a restart never closes their ports
+	serverEntryPoints, err := ServerEntryPoints(ctx, executor)
+	if err != nil {
+		return err
+	}
+	for _, entryPoint := range serverEntryPoints {
+		if !slices.Contains(config.EntryPoints, entryPoint) {
+			config.EntryPoints = append(config.EntryPoints, entryPoint)
+		}
+	}
+	slices.SortFunc(config.EntryPoints, func(a, b TraefikEntryPoint) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	composeFile, err := yaml.Marshal(TraefikComposeFile(config))
+	if err != nil {
+		return err
+	}
+	if err := executor.WriteFile(ctx, fmt.Sprintf("%s/docker-compose.yml", TraefikDir), composeFile); err != nil {
+		return fmt.Errorf("failed to update the Traefik compose file: %w", err)
+	}
+	up := fmt.Sprintf("docker compose -p sidekick up -d %s", TraefikService)
+	if len(config.DNSChallenge.Keys) > 0 {
+		if err := EnsureAgeKey(ctx, executor); err != nil {
+			return err
+		}
+		up = SopsExecEnv("encrypted.env", up)
+	}
+	// created by docker as root otherwise
+	if _, err := executor.Run(ctx, fmt.Sprintf("cd %s && mkdir -p certs dynamic && %s", TraefikDir, up)); err != nil {
+		return fmt.Errorf("failed to restart Traefik: %w", err)
+	}
+	return nil
+}
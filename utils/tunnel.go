@@ -0,0 +1,92 @@
+/*
+Copyright © 2024 Mahmoud Mousa <m.mousa@hey.com>
+
+Licensed under the GNU GPL License, Version 3.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+https://www.gnu.org/licenses/gpl-3.0.en.html
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DatabaseAddress finds the address of the database container of the app on the sidekick network
+func DatabaseAddress(ctx context.Context, executor Executor, appConfig SidekickAppConfig) (string, error) {
+	engine, ok := DatabaseEngines[appConfig.DatabaseConfig.Type]
+	if !ok {
+		return "", fmt.Errorf("%s has no database - run sidekick db create first", appConfig.Name)
+	}
+	cmd := fmt.Sprintf(
+		`docker ps -q -f label=com.docker.compose.project=sidekick -f label=com.docker.compose.service=%s | head -n 1 | xargs -r docker inspect -f '{{ (index .NetworkSettings.Networks "sidekick").IPAddress }}'`,
+		DatabaseServiceName(appConfig.Name),
+	)
+	output, err := executor.Query(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	ip := strings.TrimSpace(output)
+	if ip == "" {
+		return "", fmt.Errorf("the database of %s is not running", appConfig.Name)
+	}
+	return net.JoinHostPort(ip, fmt.Sprint(engine.Port)), nil
+}
+
+// ForwardPort listens on localAddr and tunnels every connection through the
+// SSH client to remoteAddr, like ssh -L. Close the listener to stop it
+func ForwardPort(client *ssh.Client, localAddr string, remoteAddr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", localAddr, err)
+	}
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer local.Close()
+				remote, err := client.Dial("tcp", remoteAddr)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				pipe(local, remote)
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// pipe copies both ways until either side is done
+func pipe(a net.Conn, b net.Conn) {
+	var once sync.Once
+	done := make(chan struct{})
+	closeBoth := func() {
+		once.Do(func() { close(done) })
+	}
+	go func() {
+		io.Copy(a, b)
+		closeBoth()
+	}()
+	go func() {
+		io.Copy(b, a)
+		closeBoth()
+	}()
+	<-done
+}
@@ -14,6 +14,8 @@ limitations under the License.
 */
 package utils
 
+import "slices"
+
 type DependsOn struct {
 	Condition string `yaml:"condition"`
 }
@@ -27,7 +29,7 @@ type Healthcheck struct {
 
 type DockerService struct {
 	Image       string               `yaml:"image"`
-	Command     string               `yaml:"command,omitempty"`
+	Command     []string             `yaml:"command,omitempty"`
 	Restart     string               `yaml:"restart,omitempty"`
 	Ports       []string             `yaml:"ports,omitempty"`
 	Volumes     []string             `yaml:"volumes,omitempty"`
@@ -53,14 +55,106 @@ type DockerVolume struct {
 	Driver string `yaml:"driver,omitempty"`
 }
 type SidekickAppEnvConfig struct {
-	File string `yaml:"file"`
-	Hash string `yaml:"hash"`
+	// File is the single env file of apps launched before Files existed
+	File string `yaml:"file,omitempty"`
+	// Files are merged in order, a key in a later file wins
+	Files []string `yaml:"files,omitempty"`
+	Hash  string   `yaml:"hash"`
+	// Vars are plain values that are not secret. They go in the compose file as is
+	Vars map[string]string `yaml:"vars,omitempty"`
+	// Managed keys are set with sidekick env set and live only on the server
+	// Their server values win over the env file when deploy re-encrypts it
+	Managed []string                 `yaml:"managed,omitempty"`
+	Preview SidekickPreviewEnvConfig `yaml:"preview,omitempty"`
+}
+
+// SidekickPreviewEnvConfig overrides keys of the app env in previews only
+type SidekickPreviewEnvConfig struct {
+	Files []string          `yaml:"files,omitempty"`
+	Vars  map[string]string `yaml:"vars,omitempty"`
+}
+
+// EnvFiles returns the env files of the app in the order they are merged
+func (e SidekickAppEnvConfig) EnvFiles() []string {
+	files := []string{}
+	if e.File != "" && !slices.Contains(e.Files, e.File) {
+		files = append(files, e.File)
+	}
+	return append(files, e.Files...)
+}
+
+// HasEnv tells if the app has secrets, which means it runs through sops exec-env
+func (e SidekickAppEnvConfig) HasEnv() bool {
+	return len(e.EnvFiles()) > 0 || len(e.Managed) > 0
+}
+
+func (e SidekickAppEnvConfig) HasPreviewEnv() bool {
+	return e.HasEnv() || len(e.Preview.Files) > 0
+}
+
+// PreviewVars are the plain vars of previews, with the preview ones winning
+func (e SidekickAppEnvConfig) PreviewVars() map[string]string {
+	vars := map[string]string{}
+	for key, value := range e.Vars {
+		vars[key] = value
+	}
+	for key, value := range e.Preview.Vars {
+		vars[key] = value
+	}
+	return vars
+}
+
+// SidekickAppDomainConfig is a domain the app is served on. Url always holds the primary one
+type SidekickAppDomainConfig struct {
+	Host    string `yaml:"host"`
+	Primary bool   `yaml:"primary,omitempty"`
+	// Redirect sends visitors to the primary domain instead of serving the app
+	Redirect   bool   `yaml:"redirect,omitempty"`
+	PathPrefix string `yaml:"pathPrefix,omitempty"`
+	// CustomCert serves the certificate added with sidekick certs add instead of one from Let's Encrypt
+	CustomCert bool `yaml:"customCert,omitempty"`
+}
+
+// SidekickAppMiddlewaresConfig are the Traefik middlewares in front of the app
+type SidekickAppMiddlewaresConfig struct {
+	// BasicAuth is the env key holding htpasswd users, like user:$apr1$...
+	BasicAuth       string                  `yaml:"basicAuth,omitempty"`
+	IPAllowList     []string                `yaml:"ipAllowList,omitempty"`
+	RateLimit       SidekickRateLimitConfig `yaml:"rateLimit,omitempty"`
+	Compress        bool                    `yaml:"compress,omitempty"`
+	SecurityHeaders bool                    `yaml:"securityHeaders,omitempty"`
+	RequestHeaders  map[string]string       `yaml:"requestHeaders,omitempty"`
+	ResponseHeaders map[string]string       `yaml:"responseHeaders,omitempty"`
+	// Previews puts the same middlewares in front of previews
+	Previews bool `yaml:"previews,omitempty"`
+}
+
+// SidekickRateLimitConfig allows Average requests per second per client, with bursts up to Burst
+type SidekickRateLimitConfig struct {
+	Average int `yaml:"average,omitempty"`
+	Burst   int `yaml:"burst,omitempty"`
+}
+
+// SidekickAppStreamConfig routes a non HTTP port of the app through Traefik,
+// either on a dedicated ListenPort of the server or by SNI on 443 for TLS over TCP
+type SidekickAppStreamConfig struct {
+	Name       string `yaml:"name"`
+	Port       uint64 `yaml:"port"`
+	ListenPort uint64 `yaml:"listenPort,omitempty"`
+	SNI        string `yaml:"sni,omitempty"`
+}
+
+// SidekickAppPreviewConfig configures the previews of the app
+type SidekickAppPreviewConfig struct {
+	// Auth protects previews with basic auth (the default), a token header or none
+	Auth string `yaml:"auth,omitempty"`
 }
 
 type SidekickPreview struct {
 	Url       string `yaml:"url"`
 	Image     string `yaml:"image"`
 	CreatedAt string `yaml:"createdAt"`
+	Auth      string `yaml:"auth,omitempty"`
 }
 
 type SidekickAppDatabaseBackupConfig struct {
@@ -69,22 +163,33 @@ type SidekickAppDatabaseBackupConfig struct {
 	BucketPath   string `yaml:"path"`
 	BucketRegion string `yaml:"region"`
 	S3Endpoint   string `yaml:"s3Endpoint"`
+	// Schedule is a cron expression, Retention the number of backups kept in the bucket
+	Schedule  string `yaml:"schedule,omitempty"`
+	Retention int    `yaml:"retention,omitempty"`
 }
 
 type SidekickAppDatabaseConfig struct {
 	Type   string                          `yaml:"type"`
+	Image  string                          `yaml:"image,omitempty"`
 	DbName string                          `yaml:"databaseName"`
 	Backup SidekickAppDatabaseBackupConfig `yaml:"backup,omitempty"`
 }
 
 type SidekickAppConfig struct {
-	Name           string                     `yaml:"name"`
-	Version        string                     `yaml:"version"`
-	Image          string                     `yaml:"image"`
-	Url            string                     `yaml:"url"`
-	Port           uint64                     `yaml:"port"`
-	CreatedAt      string                     `yaml:"createdAt"`
-	Env            SidekickAppEnvConfig       `yaml:"env,omitempty"`
-	DatabaseConfig SidekickAppDatabaseConfig  `yaml:"database,omitempty"`
-	PreviewEnvs    map[string]SidekickPreview `yaml:"previewEnvs,omitempty"`
+	Name    string                    `yaml:"name"`
+	Version string                    `yaml:"version"`
+	Image   string                    `yaml:"image"`
+	Url     string                    `yaml:"url"`
+	Domains []SidekickAppDomainConfig `yaml:"domains,omitempty"`
+	// Wildcard gets one *.<url> certificate with the DNS challenge so previews don't wait for their own
+	Wildcard       bool                         `yaml:"wildcard,omitempty"`
+	Middlewares    SidekickAppMiddlewaresConfig `yaml:"middlewares,omitempty"`
+	Preview        SidekickAppPreviewConfig     `yaml:"preview,omitempty"`
+	TCP            []SidekickAppStreamConfig    `yaml:"tcp,omitempty"`
+	UDP            []SidekickAppStreamConfig    `yaml:"udp,omitempty"`
+	Port           uint64                       `yaml:"port"`
+	CreatedAt      string                       `yaml:"createdAt"`
+	Env            SidekickAppEnvConfig         `yaml:"env,omitempty"`
+	DatabaseConfig SidekickAppDatabaseConfig    `yaml:"database,omitempty"`
+	PreviewEnvs    map[string]SidekickPreview   `yaml:"previewEnvs,omitempty"`
 }
@@ -16,16 +16,17 @@ package utils
 
 import (
 	"bufio"
-	"crypto/md5"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/log"
 	"github.com/joho/godotenv"
 	"github.com/mightymoud/sidekick/render"
@@ -43,6 +44,12 @@ type CommandsStage struct {
 }
 
 func RunCommand(client *ssh.Client, cmd string) (chan string, chan string, error) {
+	return RunCommandContext(context.Background(), client, cmd)
+}
+
+// RunCommandContext is RunCommand but the remote command gets signalled and
+// its session closed as soon as ctx is cancelled
+func RunCommandContext(ctx context.Context, client *ssh.Client, cmd string) (chan string, chan string, error) {
 	session, err := client.NewSession()
 	errChannel := make(chan string)
 	stdOutChannel := make(chan string)
@@ -79,52 +86,131 @@ func RunCommand(client *ssh.Client, cmd string) (chan string, chan string, error
 		}
 	}()
 
-	if err := session.Run(cmd); err != nil {
+	if err := runSession(ctx, session, cmd); err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
 		defer session.Close()
 		errString := <-errChannel
-		return nil, nil, fmt.Errorf("error running command - %s: - %s", cmd, errString)
+		return nil, nil, commandError(cmd, errString)
 	}
 
 	time.Sleep(time.Millisecond * 500)
 	return stdOutChannel, errChannel, nil
 }
 
-func RunCommandWithTUIHook(client *ssh.Client, cmd string, p *tea.Program) {
+// RunCommandOutput runs cmd and returns everything it printed to stdout
+func RunCommandOutput(ctx context.Context, client *ssh.Client, cmd string) (string, error) {
 	session, err := client.NewSession()
 	if err != nil {
-		log.Fatalf("Failed to create session: %s", err)
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := runSession(ctx, session, cmd); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return stdout.String(), commandError(cmd, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// RunCommandInput is RunCommandOutput with input sent on the session stdin
+// Use it for secrets so they never show up in the remote command line
+func RunCommandInput(ctx context.Context, client *ssh.Client, cmd string, input []byte) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = bytes.NewReader(input)
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := runSession(ctx, session, cmd); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return stdout.String(), commandError(cmd, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// commandError never includes registered secrets since it ends up in sidekick.logs.txt
+func commandError(cmd string, detail string) error {
+	return fmt.Errorf("error running command - %s: - %s", render.Redact(cmd), render.Redact(detail))
+}
+
+func RunCommandWithTUIHook(client *ssh.Client, cmd string, p render.Output) {
+	if err := RunCommandWithTUIHookContext(context.Background(), client, cmd, p); err != nil {
+		p.Send(render.ErrorMsg{ErrorStr: err.Error()})
+	}
+}
+
+// RunCommandWithTUIHookContext streams the command output to p and returns
+// the error instead of sending it, so callers can tell a failure from a cancel
+func RunCommandWithTUIHookContext(ctx context.Context, client *ssh.Client, cmd string, p render.Output) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
 	stdoutReader, err := session.StdoutPipe()
 	if err != nil {
-		p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("error running command - %s: - %s", cmd, err) + err.Error()})
+		return commandError(cmd, err.Error())
 	}
 	stderrReader, err := session.StderrPipe()
 	if err != nil {
-		p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("error running command - %s: - %s", cmd, err) + err.Error()})
+		return commandError(cmd, err.Error())
 	}
 
-	stdoutScanner := bufio.NewScanner(stdoutReader)
-	stderrScanner := bufio.NewScanner(stderrReader)
-
-	if err := session.Start(cmd); err != nil {
-		time.Sleep(time.Millisecond * 100)
-		p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("error running command - %s: - %s", cmd, err) + err.Error()})
+	var wg sync.WaitGroup
+	for _, reader := range []io.Reader{stdoutReader, stderrReader} {
+		wg.Add(1)
+		go func(reader io.Reader) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(reader)
+			for scanner.Scan() {
+				p.Send(render.LogMsg{LogLine: scanner.Text() + "\n"})
+				time.Sleep(time.Millisecond * 50)
+			}
+		}(reader)
 	}
 
-	for stdoutScanner.Scan() {
-		p.Send(render.LogMsg{LogLine: stdoutScanner.Text() + "\n"})
-		time.Sleep(time.Millisecond * 50)
+	err = runSession(ctx, session, cmd)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	wg.Wait()
+	if err != nil {
+		return commandError(cmd, err.Error())
 	}
+	return nil
+}
 
-	for stderrScanner.Scan() {
-		p.Send(render.LogMsg{LogLine: stderrScanner.Text() + "\n"})
-		time.Sleep(time.Millisecond * 50)
+// runSession runs cmd and waits for it, or interrupts it when ctx is done
+func runSession(ctx context.Context, session *ssh.Session, cmd string) error {
+	if err := session.Start(cmd); err != nil {
+		return err
 	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
 
-	if err := session.Wait(); err != nil {
-		p.Send(render.ErrorMsg{ErrorStr: fmt.Sprintf("error running command - %s: - %s", cmd, err) + err.Error()})
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGINT)
+		session.Close()
+		return ctx.Err()
 	}
 }
 
@@ -139,13 +225,22 @@ func RunCommands(client *ssh.Client, commands []string) error {
 	return nil
 }
 
-func RunCommandsWithTUIHook(client *ssh.Client, commands []string, p *tea.Program) error {
+func RunCommandsWithTUIHook(client *ssh.Client, commands []string, p render.Output) error {
 	for _, cmd := range commands {
 		RunCommandWithTUIHook(client, cmd, p)
 	}
 	return nil
 }
 
+func RunCommandsWithTUIHookContext(ctx context.Context, client *ssh.Client, commands []string, p render.Output) error {
+	for _, cmd := range commands {
+		if err := RunCommandWithTUIHookContext(ctx, client, cmd, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func RunStage(client *ssh.Client, stage CommandsStage) error {
 	if err := RunCommands(client, stage.Commands); err != nil {
 		return err
@@ -185,6 +280,7 @@ func ViperInit() error {
 	if err != nil {
 		return err
 	}
+	render.RegisterSecret(viper.GetString("secretKey"))
 	return nil
 }
 
@@ -201,6 +297,21 @@ func LoadAppConfig() (SidekickAppConfig, error) {
 	if err := yaml.Unmarshal(content, &appConfigFile); err != nil {
 		panic(err)
 	}
+	if err := ValidateDomains(appConfigFile.Domains); err != nil {
+		return SidekickAppConfig{}, fmt.Errorf("invalid domains in sidekick.yml: %w", err)
+	}
+	if err := ValidateMiddlewares(appConfigFile.Middlewares); err != nil {
+		return SidekickAppConfig{}, fmt.Errorf("invalid middlewares in sidekick.yml: %w", err)
+	}
+	if err := ValidateStreams(appConfigFile); err != nil {
+		return SidekickAppConfig{}, fmt.Errorf("invalid tcp or udp services in sidekick.yml: %w", err)
+	}
+	if err := ValidatePreviewAuth(appConfigFile.Preview.Auth); err != nil {
+		return SidekickAppConfig{}, fmt.Errorf("invalid preview in sidekick.yml: %w", err)
+	}
+	if appConfigFile.Middlewares.BasicAuth != "" && !appConfigFile.Env.HasEnv() {
+		return SidekickAppConfig{}, fmt.Errorf("basicAuth reads the users from your env - add %s with sidekick env set first", appConfigFile.Middlewares.BasicAuth)
+	}
 
 	return appConfigFile, nil
 }
@@ -215,49 +326,12 @@ func HandleEnvFile(envFileName string, dockerEnvProperty *[]string, envFileCheck
 		return envParseErr
 	}
 
-	for key := range envMap {
-		if strings.HasPrefix(key, "_") {
-			continue
-		}
-		*dockerEnvProperty = append(*dockerEnvProperty, fmt.Sprintf("%s=${%s}", key, key))
-	}
-	// calculate and store the hash of env file to re-encrypt later on when changed
-	envFileContent, _ := godotenv.Marshal(envMap)
-	*envFileChecksum = fmt.Sprintf("%x", md5.Sum([]byte(envFileContent)))
-	envCmd := exec.Command("sops",
-		"encrypt",
-		"--output-type", "dotenv",
-		"--age", viper.GetString("publicKey"),
-		fmt.Sprintf("./%s", envFileName),
-	)
-	outfile, err := os.Create("encrypted.env")
+	*dockerEnvProperty = append(*dockerEnvProperty, ComposeEnvironment(EnvKeys(envMap), nil)...)
+	// hash the parsed values, like deploy does, so formatting changes don't count
+	*envFileChecksum = EnvHash(envMap)
+	encrypted, err := EncryptEnv(envMap, AgeRecipients())
 	if err != nil {
 		return err
 	}
-	defer outfile.Close()
-	envCmd.Stdout = outfile
-	if envCmdErr := envCmd.Run(); envCmdErr != nil {
-		return envCmdErr
-	}
-	return nil
-}
-
-func WriteEnvFile(filename string, env map[string]string) error {
-	f, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("error openign file: %w", err)
-	}
-	defer f.Close()
-
-	for key, value := range env {
-		// Check if the value contains spaces or special characters
-		// and quote it if needed.  This is important for robustness.
-		if strings.ContainsAny(value, " \t\n\r\"") {
-			value = fmt.Sprintf("\"%s\"", strings.ReplaceAll(value, "\"", "\\\"")) // Escape inner quotes
-		}
-		if _, err := f.WriteString(fmt.Sprintf("%s=%s\n", key, value)); err != nil {
-			return fmt.Errorf("error writing to file: %w", err)
-		}
-	}
-	return nil
+	return os.WriteFile("encrypted.env", encrypted, 0644)
 }